@@ -0,0 +1,583 @@
+package scp
+
+// This file contains functions for analyzing the topology of a whole
+// network, given the quorum slices of each of its nodes.
+//
+// A quorum is a non-empty set of nodes that contains at least one
+// slice of each of its members. (As elsewhere, a node is understood
+// to be in each of its own slices.) Nodes that are mentioned in a
+// QSet but are not in the network are never part of any quorum.
+
+import "sort"
+
+// QuorumIntersection tells whether every two quorums in the given
+// network intersect. If they do not, it returns a counterexample: two
+// disjoint quorums, each minimal (no proper subset of it is a
+// quorum).
+//
+// The search is exponential in the worst case but is pruned heavily
+// (see netAnalysis.search), so it is practical for networks of a few
+// dozen nodes.
+func QuorumIntersection(network map[NodeID]QSet) (ok bool, a, b NodeIDSet) {
+	na := newNetAnalysis(network, nil)
+	ok, qa, qb := na.intersection()
+	if ok {
+		return true, nil, nil
+	}
+	return false, na.toSet(qa), na.toSet(qb)
+}
+
+// MinimalQuorums returns all the minimal quorums in the given
+// network: those with no proper subset that is also a quorum. The
+// result is sorted.
+func MinimalQuorums(network map[NodeID]QSet) []NodeIDSet {
+	na := newNetAnalysis(network, nil)
+
+	// Every minimal quorum lies within a single component
+	// (see intersection).
+	var result []NodeIDSet
+	for _, c := range na.components() {
+		comp := make([]bool, len(na.ids))
+		for _, i := range c {
+			comp[i] = true
+		}
+		na.search(comp, len(c), func(q []bool) bool {
+			if na.isMinimal(q) {
+				result = append(result, na.toSet(q))
+			}
+			return true
+		})
+	}
+	sortNodeIDSets(result)
+	return result
+}
+
+// MinimalBlockingSets returns all the minimal blocking sets of q:
+// the smallest sets of nodes that include at least one node from
+// each of q's slices. The result is sorted.
+func (q QSet) MinimalBlockingSets() []NodeIDSet {
+	result := minimalSets(q.blockingSets())
+	sortNodeIDSets(result)
+	return result
+}
+
+// Returns sets of nodes that block q, including all the minimal ones.
+// Blocking q requires blocking len(q.M)-q.T+1 of its members.
+func (q QSet) blockingSets() []NodeIDSet {
+	needed := len(q.M) - q.T + 1
+	if q.T <= 0 || needed <= 0 {
+		return nil
+	}
+	var memberSets [][]NodeIDSet
+	for _, m := range q.M {
+		switch {
+		case m.N != nil:
+			memberSets = append(memberSets, []NodeIDSet{{*m.N}})
+
+		case m.Q != nil:
+			memberSets = append(memberSets, minimalSets(m.Q.blockingSets()))
+		}
+	}
+	var (
+		result []NodeIDSet
+		helper func(needed int, memberSets [][]NodeIDSet, sofar NodeIDSet)
+	)
+	helper = func(needed int, memberSets [][]NodeIDSet, sofar NodeIDSet) {
+		if needed == 0 {
+			result = append(result, sofar)
+			return
+		}
+		if needed > len(memberSets) {
+			return
+		}
+		for _, set := range memberSets[0] {
+			helper(needed-1, memberSets[1:], sofar.Union(set))
+		}
+		helper(needed, memberSets[1:], sofar)
+	}
+	helper(needed, memberSets, nil)
+	return result
+}
+
+// Criticality describes the effect on a network of the failure of one
+// of its nodes.
+type Criticality struct {
+	ID NodeID
+
+	// Stranded is the set of other nodes that belong to some quorum,
+	// but to none without ID. If ID stops, they can no longer make
+	// progress.
+	Stranded NodeIDSet
+
+	// Split tells whether some two quorums intersect only at ID. If ID
+	// is Byzantine, it can cause them to externalize different values.
+	Split bool
+}
+
+// NodeCriticality tells, for each node in the given network, what
+// happens if that node fails. The result is sorted by node ID.
+func NodeCriticality(network map[NodeID]QSet) []Criticality {
+	na := newNetAnalysis(network, nil)
+	all := make([]bool, len(na.ids))
+	for i := range all {
+		all[i] = true
+	}
+	live := na.maxQuorum(all)
+
+	var result []Criticality
+	for i, id := range na.ids {
+		c := Criticality{ID: id}
+
+		rest := make([]bool, len(na.ids))
+		for j := range rest {
+			rest[j] = j != i
+		}
+		mq := na.maxQuorum(rest)
+		for j, in := range rest {
+			if in && live[j] && !mq[j] {
+				c.Stranded = append(c.Stranded, na.ids[j])
+			}
+		}
+
+		// Intersection despite the failure of a node is intersection in
+		// the network with that node deleted from everyone's slices.
+		others := make(map[NodeID]QSet, len(network)-1)
+		for other, q := range network {
+			if other != id {
+				others[other] = q
+			}
+		}
+		ok, _, _ := newNetAnalysis(others, NodeIDSet{id}).intersection()
+		c.Split = !ok
+
+		result = append(result, c)
+	}
+	return result
+}
+
+// Tells whether every two quorums intersect.
+// If they do not, returns two disjoint minimal quorums.
+func (na *netAnalysis) intersection() (bool, []bool, []bool) {
+	// Every minimal quorum lies within a single strongly connected
+	// component of the graph in which each node points to the nodes in
+	// its QSet. (The nodes of a quorum that aren't reachable from any
+	// other node of it, within it, themselves form a quorum.) So if two
+	// components contain quorums, those quorums are disjoint.
+	var (
+		comp []bool
+		qa   []bool
+	)
+	for _, c := range na.components() {
+		set := make([]bool, len(na.ids))
+		for _, i := range c {
+			set[i] = true
+		}
+		mq := na.maxQuorum(set)
+		if !anyTrue(mq) {
+			continue
+		}
+		if qa != nil {
+			return false, na.minimize(qa), na.minimize(mq)
+		}
+		comp, qa = set, mq
+	}
+	if comp == nil {
+		return true, nil, nil
+	}
+
+	// Otherwise, if there are two disjoint quorums, both are in that
+	// component, and one of them has at most half its nodes. So it
+	// suffices to look for quorums of that size whose complements
+	// (within the component) contain a quorum.
+	var (
+		size   int
+		qb, qc []bool
+	)
+	for _, in := range comp {
+		if in {
+			size++
+		}
+	}
+	na.search(comp, size/2, func(q []bool) bool {
+		rest := make([]bool, len(q))
+		for i, in := range q {
+			rest[i] = comp[i] && !in
+		}
+		if other := na.maxQuorum(rest); anyTrue(other) {
+			qb, qc = na.minimize(q), na.minimize(other)
+			return false
+		}
+		return true
+	})
+	if qb == nil {
+		return true, nil, nil
+	}
+	return false, qb, qc
+}
+
+type netAnalysis struct {
+	ids   NodeIDSet
+	index map[NodeID]int
+	qsets []QSet
+
+	// peers[i] is the indexes of the nodes in qsets[i].
+	peers [][]int
+
+	// deleted holds nodes outside the network that are treated as
+	// belonging to every slice.
+	deleted NodeIDSet
+
+	// views[i] is for finding blocking sets of node i (see satisfied).
+	views []*qview
+}
+
+func newNetAnalysis(network map[NodeID]QSet, deleted NodeIDSet) *netAnalysis {
+	na := &netAnalysis{
+		index:   make(map[NodeID]int),
+		deleted: deleted,
+	}
+	for id := range network {
+		na.ids = na.ids.Add(id)
+	}
+	for i, id := range na.ids {
+		na.index[id] = i
+	}
+	for _, id := range na.ids {
+		q := network[id]
+		na.qsets = append(na.qsets, q)
+		var peers []int
+		for _, peer := range q.Nodes() {
+			if j, ok := na.index[peer]; ok {
+				peers = append(peers, j)
+			}
+		}
+		na.peers = append(na.peers, peers)
+	}
+
+	// Every node named anywhere needs a "message" for blocking-set
+	// searches to consider it.
+	msgs := make(map[NodeID]*Msg)
+	for _, id := range na.ids {
+		msgs[id] = &Msg{V: id, Q: network[id]}
+		for _, peer := range network[id].Nodes() {
+			if _, ok := msgs[peer]; !ok {
+				msgs[peer] = &Msg{V: peer}
+			}
+		}
+	}
+	x := newNodeIndex()
+	for _, id := range na.ids {
+		na.views = append(na.views, newQView(x, id, network[id], msgs))
+	}
+	return na
+}
+
+// Tells whether node i has a slice contained in set
+// (plus node i itself).
+// That is so exactly when the nodes outside set don't block node i,
+// which is checked with the same search the protocol uses
+// (see qview.findBlockingSet).
+func (na *netAnalysis) satisfied(i int, set []bool) bool {
+	blocking := na.views[i].findBlockingSet(fpred(func(msg *Msg) bool {
+		if j, ok := na.index[msg.V]; ok {
+			return j != i && !set[j]
+		}
+		return !na.deleted.Contains(msg.V)
+	}))
+	na.views[i].clearMemo()
+	return len(blocking) == 0
+}
+
+// Returns the largest quorum contained in set
+// (i.e., the union of all such quorums),
+// which is empty if there is none.
+// It works by repeatedly discarding nodes none of whose slices are in the set.
+func (na *netAnalysis) maxQuorum(set []bool) []bool {
+	result := make([]bool, len(set))
+	copy(result, set)
+	for {
+		var changed bool
+		for i, in := range result {
+			if in && !na.satisfied(i, result) {
+				result[i] = false
+				changed = true
+			}
+		}
+		if !changed {
+			return result
+		}
+	}
+}
+
+// Tells whether the quorum q is minimal.
+func (na *netAnalysis) isMinimal(q []bool) bool {
+	sub := make([]bool, len(q))
+	for i, in := range q {
+		if !in {
+			continue
+		}
+		copy(sub, q)
+		sub[i] = false
+		if anyTrue(na.maxQuorum(sub)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns a minimal quorum contained in the quorum q.
+func (na *netAnalysis) minimize(q []bool) []bool {
+	result := make([]bool, len(q))
+	copy(result, q)
+	for i := range result {
+		if !result[i] {
+			continue
+		}
+		result[i] = false
+		if sub := na.maxQuorum(result); anyTrue(sub) {
+			result = sub
+		} else {
+			result[i] = true
+		}
+	}
+	return result
+}
+
+// Calls f on quorums of at most maxSize nodes within avail
+// until f returns false.
+// Every minimal quorum of that size is among them;
+// some non-minimal quorums may be too.
+//
+// It builds up a set of "committed" nodes one at a time,
+// deciding for each candidate node whether to include it or exclude it.
+// A branch of the search is abandoned when:
+//   - the committed nodes form a quorum
+//     (any larger quorum containing them is not minimal);
+//   - the committed nodes are not all in the largest quorum
+//     that can be formed from the nodes not yet excluded;
+//   - there are more than maxSize committed nodes,
+//     or there would have to be to give each of them a slice.
+//
+// Candidates are chosen from the peers of committed nodes that still lack a slice,
+// since any quorum containing the committed nodes must include some of them.
+func (na *netAnalysis) search(avail []bool, maxSize int, f func([]bool) bool) {
+	committed := make([]bool, len(na.ids))
+	na.searchHelper(committed, 0, avail, maxSize, f)
+}
+
+func (na *netAnalysis) searchHelper(committed []bool, size int, avail []bool, maxSize int, f func([]bool) bool) bool {
+	if size > maxSize {
+		return true
+	}
+	mq := na.maxQuorum(avail)
+	for i, in := range committed {
+		if in && !mq[i] {
+			return true
+		}
+	}
+
+	var next = -1
+	if size == 0 {
+		for i, in := range mq {
+			if in {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			return true
+		}
+	} else {
+		// Find a peer of an unsatisfied committed node.
+		var unsatisfied bool
+		for i, in := range committed {
+			if !in || na.satisfied(i, committed) {
+				continue
+			}
+			unsatisfied = true
+			for _, j := range na.peers[i] {
+				if mq[j] && !committed[j] {
+					next = j
+					break
+				}
+			}
+			if next >= 0 {
+				break
+			}
+		}
+		if !unsatisfied {
+			return f(committed)
+		}
+		if next < 0 {
+			return true
+		}
+
+		// Any quorum containing the committed nodes must also contain
+		// enough others to give each of them a slice.
+		for i, in := range committed {
+			if in && size+na.needed(i, committed, mq) > maxSize {
+				return true
+			}
+		}
+	}
+
+	// Include next.
+	committed2 := make([]bool, len(committed))
+	copy(committed2, committed)
+	committed2[next] = true
+	if !na.searchHelper(committed2, size+1, mq, maxSize, f) {
+		return false
+	}
+
+	// Exclude next.
+	mq[next] = false
+	return na.searchHelper(committed, size, mq, maxSize, f)
+}
+
+// Returns the smallest number of nodes from avail,
+// in addition to those in committed,
+// needed to complete a slice for node i
+// (or len(na.ids)+1 if it can't be done).
+func (na *netAnalysis) needed(i int, committed, avail []bool) int {
+	return na.neededHelper(i, na.qsets[i], committed, avail)
+}
+
+func (na *netAnalysis) neededHelper(i int, q QSet, committed, avail []bool) int {
+	impossible := len(na.ids) + 1
+	costs := make([]int, 0, len(q.M))
+	for _, m := range q.M {
+		switch {
+		case m.N != nil:
+			j, ok := na.index[*m.N]
+			switch {
+			case !ok && na.deleted.Contains(*m.N):
+				costs = append(costs, 0)
+			case !ok:
+				costs = append(costs, impossible)
+			case j == i || committed[j]:
+				costs = append(costs, 0)
+			case avail[j]:
+				costs = append(costs, 1)
+			default:
+				costs = append(costs, impossible)
+			}
+
+		case m.Q != nil:
+			costs = append(costs, na.neededHelper(i, *m.Q, committed, avail))
+		}
+	}
+	if q.T > len(costs) {
+		return impossible
+	}
+	sort.Ints(costs)
+	var result int
+	for _, c := range costs[:q.T] {
+		result += c
+	}
+	if result > impossible {
+		result = impossible
+	}
+	return result
+}
+
+// Returns the strongly connected components of the graph
+// in which each node points to its peers,
+// using Tarjan's algorithm.
+func (na *netAnalysis) components() [][]int {
+	var (
+		result  [][]int
+		index   = make([]int, len(na.ids)) // 1-based; 0 means unvisited
+		lowlink = make([]int, len(na.ids))
+		onStack = make([]bool, len(na.ids))
+		stack   []int
+		counter int
+		visit   func(int)
+	)
+	visit = func(i int) {
+		counter++
+		index[i], lowlink[i] = counter, counter
+		stack = append(stack, i)
+		onStack[i] = true
+		for _, j := range na.peers[i] {
+			if index[j] == 0 {
+				visit(j)
+				if lowlink[j] < lowlink[i] {
+					lowlink[i] = lowlink[j]
+				}
+			} else if onStack[j] && index[j] < lowlink[i] {
+				lowlink[i] = index[j]
+			}
+		}
+		if lowlink[i] == index[i] {
+			var comp []int
+			for {
+				j := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[j] = false
+				comp = append(comp, j)
+				if j == i {
+					break
+				}
+			}
+			result = append(result, comp)
+		}
+	}
+	for i := range na.ids {
+		if index[i] == 0 {
+			visit(i)
+		}
+	}
+	return result
+}
+
+func (na *netAnalysis) toSet(set []bool) NodeIDSet {
+	var result NodeIDSet
+	for i, in := range set {
+		if in {
+			result = append(result, na.ids[i]) // na.ids is sorted, so this is too
+		}
+	}
+	return result
+}
+
+func anyTrue(set []bool) bool {
+	for _, in := range set {
+		if in {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the members of sets that have no subset in sets
+// (discarding duplicates).
+func minimalSets(sets []NodeIDSet) []NodeIDSet {
+	sort.SliceStable(sets, func(i, j int) bool { return len(sets[i]) < len(sets[j]) })
+	var result []NodeIDSet
+	for _, set := range sets {
+		var redundant bool
+		for _, other := range result {
+			if len(other.Minus(set)) == 0 {
+				redundant = true
+				break
+			}
+		}
+		if !redundant {
+			result = append(result, set)
+		}
+	}
+	return result
+}
+
+// Sorts sets lexicographically.
+func sortNodeIDSets(sets []NodeIDSet) {
+	sort.Slice(sets, func(i, j int) bool {
+		a, b := sets[i], sets[j]
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+}
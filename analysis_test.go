@@ -0,0 +1,393 @@
+package scp
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+func loadNetwork(t testing.TB, name string) map[NodeID]QSet {
+	var conf map[string]struct{ Q QSet }
+	_, err := toml.DecodeFile(filepath.Join("cmd", "lunch", "toml", name), &conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := make(map[NodeID]QSet)
+	for id, nconf := range conf {
+		result[NodeID(id)] = nconf.Q
+	}
+	return result
+}
+
+func TestQuorumIntersectionTOML(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("cmd", "lunch", "toml", "*.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no TOML files")
+	}
+	for _, file := range files {
+		name := filepath.Base(file)
+		t.Run(name, func(t *testing.T) {
+			network := loadNetwork(t, name)
+			ok, a, b := QuorumIntersection(network)
+			if len(network) <= 12 {
+				if want := bruteForceIntersection(network); ok != want {
+					t.Errorf("got %v, want %v (counterexample %s / %s)", ok, want, a, b)
+				}
+			}
+			if !ok {
+				checkCounterexample(t, network, a, b)
+			}
+		})
+	}
+}
+
+func TestQuorumIntersection(t *testing.T) {
+	cases := []struct {
+		network map[NodeID]QSet
+		want    bool
+	}{
+		{
+			network: map[NodeID]QSet{},
+			want:    true,
+		},
+		{
+			// Two groups that trust only themselves.
+			network: map[NodeID]QSet{
+				"a": slicesToQSet([]NodeIDSet{toNodeIDSet("b")}),
+				"b": slicesToQSet([]NodeIDSet{toNodeIDSet("a")}),
+				"c": slicesToQSet([]NodeIDSet{toNodeIDSet("d")}),
+				"d": slicesToQSet([]NodeIDSet{toNodeIDSet("c")}),
+			},
+			want: false,
+		},
+		{
+			// 2 of 3 among a, b, c; d depends on all of them.
+			network: map[NodeID]QSet{
+				"a": QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("b")}, {N: nodeIDPtr("c")}}},
+				"b": QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("a")}, {N: nodeIDPtr("c")}}},
+				"c": QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("a")}, {N: nodeIDPtr("b")}}},
+				"d": slicesToQSet([]NodeIDSet{toNodeIDSet("a b c")}),
+			},
+			want: true,
+		},
+		{
+			// 1 of 3: too weak.
+			network: map[NodeID]QSet{
+				"a": QSet{T: 0},
+				"b": QSet{T: 0},
+				"c": QSet{T: 0},
+			},
+			want: false,
+		},
+	}
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%02d", i+1), func(t *testing.T) {
+			ok, a, b := QuorumIntersection(tc.network)
+			if ok != tc.want {
+				t.Errorf("got %v, want %v (counterexample %s / %s)", ok, tc.want, a, b)
+			}
+			if !ok {
+				checkCounterexample(t, tc.network, a, b)
+			}
+		})
+	}
+}
+
+func TestQuorumIntersectionRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		network := randomNetwork(rng, 2+rng.Intn(8))
+		t.Run(fmt.Sprintf("%03d", i+1), func(t *testing.T) {
+			ok, a, b := QuorumIntersection(network)
+			want := bruteForceIntersection(network)
+			if ok != want {
+				t.Fatalf("got %v, want %v (network %v)", ok, want, network)
+			}
+			if !ok {
+				checkCounterexample(t, network, a, b)
+			}
+		})
+	}
+}
+
+func randomNetwork(rng *rand.Rand, n int) map[NodeID]QSet {
+	var ids []NodeID
+	for i := 0; i < n; i++ {
+		ids = append(ids, NodeID(fmt.Sprintf("n%d", i)))
+	}
+	result := make(map[NodeID]QSet)
+	for _, id := range ids {
+		var q QSet
+		for _, other := range ids {
+			if other != id && rng.Intn(2) == 0 {
+				other := other
+				q.M = append(q.M, QSetMember{N: &other})
+			}
+		}
+		if len(q.M) > 0 {
+			q.T = 1 + rng.Intn(len(q.M))
+		}
+		result[id] = q
+	}
+	return result
+}
+
+// Tells whether the given set is a quorum,
+// by the definition.
+func isQuorum(network map[NodeID]QSet, set NodeIDSet) bool {
+	if len(set) == 0 {
+		return false
+	}
+	for _, id := range set {
+		q, ok := network[id]
+		if !ok {
+			return false
+		}
+		if !hasSlice(q, set) {
+			return false
+		}
+	}
+	return true
+}
+
+// Tells whether some slice of q is contained in set,
+// by counting members that are in set or whose inner QSet is satisfied.
+func hasSlice(q QSet, set NodeIDSet) bool {
+	n := 0
+	for _, m := range q.M {
+		switch {
+		case m.N != nil:
+			if set.Contains(*m.N) {
+				n++
+			}
+
+		case m.Q != nil:
+			if hasSlice(*m.Q, set) {
+				n++
+			}
+		}
+	}
+	return n >= q.T
+}
+
+// Enumerates all subsets of the network.
+func allSubsets(network map[NodeID]QSet, f func(NodeIDSet)) {
+	var ids NodeIDSet
+	for id := range network {
+		ids = ids.Add(id)
+	}
+	for bits := 1; bits < 1<<uint(len(ids)); bits++ {
+		var set NodeIDSet
+		for i, id := range ids {
+			if bits&(1<<uint(i)) != 0 {
+				set = append(set, id)
+			}
+		}
+		f(set)
+	}
+}
+
+func bruteForceIntersection(network map[NodeID]QSet) bool {
+	var quorums []NodeIDSet
+	allSubsets(network, func(set NodeIDSet) {
+		if isQuorum(network, set) {
+			quorums = append(quorums, set)
+		}
+	})
+	for i, a := range quorums {
+		for _, b := range quorums[i+1:] {
+			if len(a.Intersection(b)) == 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func checkCounterexample(t *testing.T, network map[NodeID]QSet, a, b NodeIDSet) {
+	t.Helper()
+	if !isQuorum(network, a) {
+		t.Errorf("%s is not a quorum", a)
+	}
+	if !isQuorum(network, b) {
+		t.Errorf("%s is not a quorum", b)
+	}
+	if len(a.Intersection(b)) > 0 {
+		t.Errorf("%s and %s intersect", a, b)
+	}
+}
+
+// A network of n nodes, each requiring t of the others.
+func symmetricNetwork(n, t int) map[NodeID]QSet {
+	var ids []NodeID
+	for i := 0; i < n; i++ {
+		ids = append(ids, NodeID(fmt.Sprintf("n%02d", i)))
+	}
+	result := make(map[NodeID]QSet)
+	for _, id := range ids {
+		q := QSet{T: t}
+		for _, other := range ids {
+			if other != id {
+				other := other
+				q.M = append(q.M, QSetMember{N: &other})
+			}
+		}
+		result[id] = q
+	}
+	return result
+}
+
+func TestQuorumIntersectionLarge(t *testing.T) {
+	// 3f+1 nodes with quorums of 2f+1 intersect.
+	ok, a, b := QuorumIntersection(symmetricNetwork(31, 20))
+	if !ok {
+		t.Errorf("got counterexample %s / %s", a, b)
+	}
+
+	// With quorums of 2f, they don't.
+	network := symmetricNetwork(32, 15)
+	ok, a, b = QuorumIntersection(network)
+	if ok {
+		t.Error("got no counterexample")
+	} else {
+		checkCounterexample(t, network, a, b)
+	}
+}
+
+func TestSatisfied(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		network := randomNetwork(rng, 2+rng.Intn(6))
+		na := newNetAnalysis(network, nil)
+		for j, id := range na.ids {
+			var (
+				set   NodeIDSet
+				inSet = make([]bool, len(na.ids))
+			)
+			for k, other := range na.ids {
+				if rng.Intn(2) == 0 {
+					set = set.Add(other)
+					inSet[k] = true
+				}
+			}
+			want := false
+			network[id].Slices(func(slice NodeIDSet) bool {
+				want = len(slice.Minus(set.Add(id))) == 0
+				return !want
+			})
+			if got := na.satisfied(j, inSet); got != want {
+				t.Errorf("case %d, node %s, set %s: got %v, want %v", i+1, id, set, got, want)
+			}
+		}
+	}
+}
+
+func TestMinimalQuorums(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	for i := 0; i < 200; i++ {
+		network := randomNetwork(rng, 2+rng.Intn(8))
+		t.Run(fmt.Sprintf("%03d", i+1), func(t *testing.T) {
+			var quorums []NodeIDSet
+			allSubsets(network, func(set NodeIDSet) {
+				if isQuorum(network, set) {
+					quorums = append(quorums, set)
+				}
+			})
+			want := minimalSets(quorums)
+			sortNodeIDSets(want)
+			got := MinimalQuorums(network)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+
+	got := MinimalQuorums(loadNetwork(t, "3tiers.toml"))
+	want := []NodeIDSet{
+		toNodeIDSet("alice bob carol"),
+		toNodeIDSet("alice bob dave"),
+		toNodeIDSet("alice carol dave"),
+		toNodeIDSet("bob carol dave"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("3tiers: got %v, want %v", got, want)
+	}
+}
+
+func TestMinimalBlockingSets(t *testing.T) {
+	cases := []struct {
+		q    QSet
+		want []string
+	}{
+		{
+			q: QSet{T: 0},
+		},
+		{
+			q:    QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("a")}, {N: nodeIDPtr("b")}}},
+			want: []string{"a b"},
+		},
+		{
+			q:    QSet{T: 2, M: []QSetMember{{N: nodeIDPtr("a")}, {N: nodeIDPtr("b")}}},
+			want: []string{"a", "b"},
+		},
+		{
+			q: QSet{T: 2, M: []QSetMember{
+				{N: nodeIDPtr("a")},
+				{N: nodeIDPtr("b")},
+				{Q: &QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("c")}, {N: nodeIDPtr("d")}}}},
+			}},
+			want: []string{"a b", "a c d", "b c d"},
+		},
+		{
+			// Overlapping inner sets.
+			q: QSet{T: 2, M: []QSetMember{
+				{Q: &QSet{T: 2, M: []QSetMember{{N: nodeIDPtr("a")}, {N: nodeIDPtr("b")}}}},
+				{Q: &QSet{T: 2, M: []QSetMember{{N: nodeIDPtr("a")}, {N: nodeIDPtr("c")}}}},
+			}},
+			want: []string{"a", "b", "c"},
+		},
+	}
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%02d", i+1), func(t *testing.T) {
+			var want []NodeIDSet
+			for _, s := range tc.want {
+				want = append(want, toNodeIDSet(s))
+			}
+			got := tc.q.MinimalBlockingSets()
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestNodeCriticality(t *testing.T) {
+	// Quorums {a b} and {b c} meet only at b, and a and c depend on b.
+	network := map[NodeID]QSet{
+		"a": slicesToQSet([]NodeIDSet{toNodeIDSet("b")}),
+		"b": QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("a")}, {N: nodeIDPtr("c")}}},
+		"c": slicesToQSet([]NodeIDSet{toNodeIDSet("b")}),
+	}
+	got := NodeCriticality(network)
+	want := []Criticality{
+		{ID: "a"},
+		{ID: "b", Stranded: toNodeIDSet("a c"), Split: true},
+		{ID: "c"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	for _, c := range NodeCriticality(loadNetwork(t, "3tiers.toml")) {
+		if len(c.Stranded) > 0 || c.Split {
+			t.Errorf("3tiers: got %+v, want no effect", c)
+		}
+	}
+}
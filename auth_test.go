@@ -0,0 +1,110 @@
+package scp
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"testing"
+)
+
+func TestSignVerify(t *testing.T) {
+	xPub, xPrv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	yPub, yPrv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := NewEd25519Verifier(map[NodeID]ed25519.PublicKey{"x": xPub, "y": yPub}, valtypeCodec{})
+	ySigner := &Ed25519Signer{Key: yPrv, Codec: valtypeCodec{}}
+
+	ch := make(chan *Msg, 10)
+	q := QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("y")}}}
+	yQ := QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("x")}}}
+	n := NewNode("x", q, ch, WithSigner(&Ed25519Signer{Key: xPrv, Codec: valtypeCodec{}}), WithVerifier(verifier))
+
+	newYMsg := func() *Msg {
+		msg := NewMsg("y", 1, yQ, &PrepTopic{B: Ballot{1, valtype(7)}, P: Ballot{1, valtype(7)}})
+		sig, err := ySigner.Sign(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg.S = sig
+		return msg
+	}
+
+	// Unsigned, tampered, and misattributed messages are all dropped.
+	unsigned := newYMsg()
+	unsigned.S = nil
+	tampered := newYMsg()
+	tampered.T.(*PrepTopic).B.N = 2
+	misattributed := newYMsg()
+	misattributed.V = "z"
+	for _, msg := range []*Msg{unsigned, tampered, misattributed} {
+		n.Handle(msg)
+		n.Step()
+	}
+	if len(ch) != 0 {
+		t.Fatalf("got %d outbound message(s) in response to bad messages, want 0", len(ch))
+	}
+	if err = verifier.Verify(tampered); !errors.Is(err, ErrBadSig) {
+		t.Errorf("got error %v, want %s", err, ErrBadSig)
+	}
+
+	// Nor is a message claiming to be from the node itself exempt.
+	forged := NewMsg("x", 1, q, &PrepTopic{B: Ballot{1, valtype(7)}})
+	if err = n.verify(forged); err == nil {
+		t.Error("unsigned message from x verified")
+	}
+
+	// A properly signed message gets a signed response.
+	n.Handle(newYMsg())
+	n.Step()
+	if len(ch) != 1 {
+		t.Fatalf("got %d outbound message(s), want 1", len(ch))
+	}
+	sent := <-ch
+	if sent.S == nil {
+		t.Fatal("outbound message is unsigned")
+	}
+	err = verifier.Verify(sent)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSignedMsgRoundTrip(t *testing.T) {
+	pub, prv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := &Ed25519Signer{Key: prv, Codec: valtypeCodec{}}
+	verifier := NewEd25519Verifier(map[NodeID]ed25519.PublicKey{"x": pub}, valtypeCodec{})
+
+	msg := NewMsg("x", 1, QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("y")}}}, &CommitTopic{B: Ballot{2, valtype(7)}, PN: 2, HN: 2, CN: 1})
+	msg.S, err = signer.Sign(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := MarshalMsg(msg, valtypeCodec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := UnmarshalMsg(b, valtypeCodec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = verifier.Verify(got); err != nil {
+		t.Error(err)
+	}
+
+	// Corrupting the signature in transit makes it fail.
+	b[len(b)-1] ^= 1
+	got, err = UnmarshalMsg(b, valtypeCodec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = verifier.Verify(got); !errors.Is(err, ErrBadSig) {
+		t.Errorf("got error %v, want %s", err, ErrBadSig)
+	}
+}
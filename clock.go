@@ -0,0 +1,161 @@
+package scp
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for a Node and its slots. It governs
+// nomination rounds, deferred ballot updates, and the cap on ballot
+// counters.
+type Clock interface {
+	// Now tells the current time.
+	Now() time.Time
+
+	// AfterFunc arranges for f to be called (in its own goroutine or
+	// not, at the clock's discretion) after duration d has elapsed.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a pending call created by Clock.AfterFunc.
+type Timer interface {
+	// Stop prevents the timer from firing. It returns false if the
+	// timer has already fired or been stopped.
+	Stop() bool
+}
+
+// RealClock is a Clock that uses the time package.
+var RealClock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// FakeClock is a Clock whose time advances only when told to. Timer
+// functions are called synchronously, in the goroutine advancing the
+// clock, in order of their deadlines (ties broken by creation
+// order). It is safe for concurrent use.
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	seq    int
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	c    *FakeClock
+	when time.Time
+	seq  int
+	f    func()
+}
+
+// NewFakeClock produces a new FakeClock whose current time is t.
+func NewFakeClock(t time.Time) *FakeClock {
+	return &FakeClock{now: t}
+}
+
+// Now tells the clock's current time.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// AfterFunc arranges for f to be called when the clock is advanced
+// by d or more.
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	t := &fakeTimer{
+		c:    c,
+		when: c.now.Add(d),
+		seq:  c.seq,
+		f:    f,
+	}
+	index := sort.Search(len(c.timers), func(i int) bool {
+		return t.before(c.timers[i])
+	})
+	c.timers = append(c.timers, nil)
+	copy(c.timers[index+1:], c.timers[index:])
+	c.timers[index] = t
+	return t
+}
+
+// Advance moves the clock forward by d, firing any timers whose
+// deadlines are reached along the way.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.AdvanceTo(c.Now().Add(d))
+}
+
+// AdvanceTo moves the clock forward to t, firing any timers whose
+// deadlines are reached along the way. While each timer function
+// runs, the clock reads as that timer's deadline. It is a no-op if
+// t is not after the current time.
+func (c *FakeClock) AdvanceTo(t time.Time) {
+	for {
+		c.mu.Lock()
+		if len(c.timers) == 0 || c.timers[0].when.After(t) {
+			if t.After(c.now) {
+				c.now = t
+			}
+			c.mu.Unlock()
+			return
+		}
+		timer := c.timers[0]
+		c.timers = c.timers[1:]
+		if timer.when.After(c.now) {
+			c.now = timer.when
+		}
+		c.mu.Unlock()
+
+		timer.f()
+	}
+}
+
+// Next tells the deadline of the earliest pending timer. The boolean
+// result is false if there are no pending timers.
+func (c *FakeClock) Next() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.timers) == 0 {
+		return time.Time{}, false
+	}
+	return c.timers[0].when, true
+}
+
+// Pending tells the number of timers that have neither fired nor
+// been stopped.
+func (c *FakeClock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+func (t *fakeTimer) before(other *fakeTimer) bool {
+	if t.when.Before(other.when) {
+		return true
+	}
+	if other.when.Before(t.when) {
+		return false
+	}
+	return t.seq < other.seq
+}
+
+func (t *fakeTimer) Stop() bool {
+	c := t.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, other := range c.timers {
+		if other == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package scp
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Unix(1000, 0)
+	c := NewFakeClock(start)
+
+	var got []int
+	c.AfterFunc(3*time.Second, func() { got = append(got, 3) })
+	c.AfterFunc(1*time.Second, func() { got = append(got, 1) })
+	c.AfterFunc(2*time.Second, func() { got = append(got, 2) })
+	c.AfterFunc(2*time.Second, func() { got = append(got, 22) })
+	t4 := c.AfterFunc(4*time.Second, func() { got = append(got, 4) })
+
+	c.Advance(2 * time.Second)
+	if want := []int{1, 2, 22}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after 2s got %v, want %v", got, want)
+	}
+	if !c.Now().Equal(start.Add(2 * time.Second)) {
+		t.Errorf("got now %s, want %s", c.Now(), start.Add(2*time.Second))
+	}
+
+	if !t4.Stop() {
+		t.Error("Stop returned false for a pending timer")
+	}
+	if t4.Stop() {
+		t.Error("Stop returned true for a stopped timer")
+	}
+
+	next, ok := c.Next()
+	if !ok || !next.Equal(start.Add(3*time.Second)) {
+		t.Errorf("got Next() = %s, %v; want %s, true", next, ok, start.Add(3*time.Second))
+	}
+
+	c.Advance(time.Minute)
+	if want := []int{1, 2, 22, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after 1m got %v, want %v", got, want)
+	}
+	if _, ok := c.Next(); ok {
+		t.Error("got pending timers, want none")
+	}
+}
+
+func TestSlotRoundsFakeClock(t *testing.T) {
+	c := NewFakeClock(time.Unix(1000, 0))
+	ch := make(chan *Msg)
+	node := NewNode("x", slicesToQSet([]NodeIDSet{{"a"}}), ch, WithClock(c))
+	slot, err := newSlot(1, node)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := slot.Round(); got != 1 {
+		t.Errorf("got round %d, want 1", got)
+	}
+
+	// Round 1 lasts 3 intervals. Advancing to the start of round 2
+	// fires the round timer, which queues a newRoundCmd.
+	c.Advance(3 * node.Config().NomRoundInterval)
+	if got := slot.Round(); got != 2 {
+		t.Errorf("got round %d, want 2", got)
+	}
+	cmd, ok := node.cmds.read(context.Background())
+	if !ok {
+		t.Fatal("no command queued")
+	}
+	if _, ok := cmd.(*newRoundCmd); !ok {
+		t.Fatalf("got command %T, want *newRoundCmd", cmd)
+	}
+}
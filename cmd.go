@@ -2,6 +2,8 @@ package scp
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"sync"
 )
 
@@ -17,60 +19,274 @@ type deferredUpdateCmd struct {
 	slot *Slot
 }
 
+type raiseBNCmd struct {
+	slot *Slot
+}
+
 type newRoundCmd struct {
 	slot *Slot
 }
 
+type rebroadcastCmd struct{}
+
 type rehandleCmd struct {
 	slot *Slot
 }
 
+type nominateCmd struct {
+	i     SlotID
+	vals  ValueSet
+	reply chan<- nominateResult
+}
+
+type nominateResult struct {
+	eligible bool
+	err      error
+}
+
 type delayCmd struct {
 	ms int
 }
 
-// Internal channel for queueing and processing commands.
+type endDelayCmd struct{}
+
+// ErrQueueFull is the error returned by Node.Handle when the node's
+// queue of peer messages is full and its overflow policy is
+// OverflowError.
+var ErrQueueFull = errors.New("command queue is full")
+
+// ErrStopped is the error returned by Node.Handle once the node's Run
+// method has returned.
+var ErrStopped = errors.New("node stopped")
+
+// OverflowPolicy says what Node.Handle does when the node's queue of
+// peer messages is full (see NodeConfig).
+type OverflowPolicy int
+
+const (
+	// OverflowBlock makes Handle wait for room in the queue.
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowDropOldest makes Handle discard the oldest queued peer
+	// message to make room.
+	OverflowDropOldest
+
+	// OverflowError makes Handle return ErrQueueFull.
+	OverflowError
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowBlock:
+		return "block"
+	case OverflowDropOldest:
+		return "drop-oldest"
+	case OverflowError:
+		return "error"
+	}
+	return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+}
+
+// QueueStats reports on a node's command queue (see Node.QueueStats).
+type QueueStats struct {
+	// Depth is the number of peer messages waiting to be processed.
+	// Internal is the number of other commands: timer events,
+	// nominations, simulated delays, and so on. These take
+	// priority over peer messages and are never dropped.
+	Depth, Internal int
+
+	// MaxDepth is the highest Depth seen.
+	MaxDepth int
+
+	// Dropped counts peer messages discarded under
+	// OverflowDropOldest. Rejected counts those refused under
+	// OverflowError.
+	Dropped, Rejected uint64
+}
+
+// Internal queue of commands for the node goroutine.
+// Commands arising inside the node (timers, nominations, replays)
+// go on the unbounded hi queue,
+// since they may be written by the node goroutine itself,
+// which must not block.
+// Commands from peers go on the bounded lo channel
+// and are read only when hi is empty
+// and no simulated delay is in effect (see pause).
+type cmdQueue struct {
+	policy OverflowPolicy
+
+	mu       sync.Mutex
+	hi       []Cmd
+	paused   bool
+	maxDepth int
+	dropped  uint64
+	rejected uint64
+
+	// hiReady has a value in it whenever hi might be non-empty.
+	hiReady chan struct{}
+
+	lo chan Cmd
+
+	// done is closed when the queue's reader goes away (see stop).
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newCmdQueue(capacity int, policy OverflowPolicy) *cmdQueue {
+	return &cmdQueue{
+		policy:  policy,
+		hiReady: make(chan struct{}, 1),
+		lo:      make(chan Cmd, capacity),
+		done:    make(chan struct{}),
+	}
+}
+
+// Tells writers of lo commands that nothing will read them any more,
+// so they get ErrStopped instead of waiting forever for room.
+func (q *cmdQueue) stop() {
+	q.stopOnce.Do(func() { close(q.done) })
+}
+
+// Queues an internal command.
+func (q *cmdQueue) writeHi(cmd Cmd) {
+	q.mu.Lock()
+	q.hi = append(q.hi, cmd)
+	q.mu.Unlock()
+
+	select {
+	case q.hiReady <- struct{}{}:
+	default:
+	}
+}
+
+// Queues a command from a peer, applying the overflow policy if the
+// queue is full.
+func (q *cmdQueue) writeLo(cmd Cmd) error {
+	defer q.noteDepth()
+
+	select {
+	case <-q.done:
+		return ErrStopped
+	default:
+	}
+
+	select {
+	case q.lo <- cmd:
+		return nil
+	default:
+	}
+
+	switch q.policy {
+	case OverflowDropOldest:
+		for {
+			select {
+			case q.lo <- cmd:
+				return nil
+			default:
+			}
+			select {
+			case <-q.lo:
+				q.mu.Lock()
+				q.dropped++
+				q.mu.Unlock()
+			default:
+			}
+		}
+
+	case OverflowError:
+		q.mu.Lock()
+		q.rejected++
+		q.mu.Unlock()
+		return ErrQueueFull
+	}
 
-type cmdChan struct {
-	cond sync.Cond
-	cmds []Cmd
+	select {
+	case q.lo <- cmd:
+		return nil
+	case <-q.done:
+		return ErrStopped
+	}
 }
 
-func newCmdChan() *cmdChan {
-	result := new(cmdChan)
-	result.cond.L = new(sync.Mutex)
-	return result
+func (q *cmdQueue) noteDepth() {
+	depth := len(q.lo)
+	q.mu.Lock()
+	if depth > q.maxDepth {
+		q.maxDepth = depth
+	}
+	q.mu.Unlock()
 }
 
-func (c *cmdChan) write(cmd Cmd) {
-	c.cond.L.Lock()
-	c.cmds = append(c.cmds, cmd)
-	c.cond.Broadcast()
-	c.cond.L.Unlock()
+// Stops lo commands from being read until resume is called.
+func (q *cmdQueue) pause() {
+	q.mu.Lock()
+	q.paused = true
+	q.mu.Unlock()
 }
 
-func (c *cmdChan) read(ctx context.Context) (Cmd, bool) {
-	c.cond.L.Lock()
-	defer c.cond.L.Unlock()
+// Undoes pause.
+func (q *cmdQueue) resume() {
+	q.mu.Lock()
+	q.paused = false
+	q.mu.Unlock()
+}
 
-	for len(c.cmds) == 0 {
-		ch := make(chan struct{})
-		go func() {
-			c.cond.Wait()
-			close(ch)
-		}()
+// Returns q.lo, or nil (which blocks forever) if q is paused.
+func (q *cmdQueue) loChan() chan Cmd {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.paused {
+		return nil
+	}
+	return q.lo
+}
 
+// Waits for the next command, preferring internal ones.
+func (q *cmdQueue) read(ctx context.Context) (Cmd, bool) {
+	for {
+		if cmd, ok := q.tryRead(); ok {
+			return cmd, true
+		}
 		select {
 		case <-ctx.Done():
 			return nil, false
 
-		case <-ch:
-			if len(c.cmds) == 0 {
-				continue
-			}
+		case <-q.hiReady:
+
+		case cmd := <-q.loChan():
+			return cmd, true
 		}
 	}
-	result := c.cmds[0]
-	c.cmds = c.cmds[1:]
-	return result, true
+}
+
+// Returns the next command without waiting, preferring internal ones.
+func (q *cmdQueue) tryRead() (Cmd, bool) {
+	q.mu.Lock()
+	if len(q.hi) > 0 {
+		result := q.hi[0]
+		q.hi[0] = nil
+		q.hi = q.hi[1:]
+		q.mu.Unlock()
+		return result, true
+	}
+	q.mu.Unlock()
+
+	select {
+	case cmd := <-q.loChan():
+		return cmd, true
+	default:
+		return nil, false
+	}
+}
+
+func (q *cmdQueue) stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return QueueStats{
+		Depth:    len(q.lo),
+		Internal: len(q.hi),
+		MaxDepth: q.maxDepth,
+		Dropped:  q.dropped,
+		Rejected: q.rejected,
+	}
 }
@@ -73,10 +73,23 @@ func main() {
 		log.Fatal(err)
 	}
 
+	network := make(map[scp.NodeID]scp.QSet)
+	for nodeID, nconf := range conf {
+		network[scp.NodeID(nodeID)] = nconf.Q
+	}
+	if ok, a, b := scp.QuorumIntersection(network); !ok {
+		log.Printf("WARNING: network lacks quorum intersection, e.g. %s and %s", a, b)
+	}
+
+	// This loop feeds every node and also reads what every node sends,
+	// so a node must not wait for room in its queue:
+	// it might be waiting for this loop to read its output.
+	cfg := scp.NodeConfig{Overflow: scp.OverflowDropOldest}
+
 	nodes := make(map[scp.NodeID]*scp.Node)
 	ch := make(chan *scp.Msg)
 	for nodeID, nconf := range conf {
-		node := scp.NewNode(scp.NodeID(nodeID), nconf.Q, ch, nil)
+		node := scp.NewNode(scp.NodeID(nodeID), nconf.Q, ch, scp.WithLogger(scp.StdLogger{Min: scp.LevelDebug}), scp.WithConfig(cfg))
 		node.FP, node.FQ = nconf.FP, nconf.FQ
 		nodes[node.ID] = node
 		go node.Run(context.Background())
@@ -89,9 +102,15 @@ func main() {
 			msgs[node.ID] = nil
 
 			// New slot! Nominate something.
+			// (Nominate waits for the node,
+			// which may be waiting for this goroutine to read from ch.)
 			val := foods[rand.Intn(len(foods))]
-			nomMsg := scp.NewMsg(node.ID, slotID, node.Q, &scp.NomTopic{X: scp.ValueSet{val}})
-			node.Handle(nomMsg)
+			go func(node *scp.Node, slotID scp.SlotID) {
+				_, err := node.Nominate(context.Background(), slotID, val)
+				if err != nil {
+					log.Printf("node %s: nominating %s: %s", node.ID, val, err)
+				}
+			}(node, slotID)
 		}
 
 		for msg := range ch {
@@ -122,7 +141,10 @@ func main() {
 				if *delay > 0 {
 					otherNode.Delay(rand.Intn(*delay))
 				}
-				otherNode.Handle(msg)
+				err := otherNode.Handle(msg)
+				if err != nil {
+					log.Printf("node %s: %s", otherNodeID, err)
+				}
 			}
 		}
 	}
@@ -150,7 +150,11 @@ func protocolHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	node.Logf("* sending %s to node.Handle", msg)
-	node.Handle(msg)
+	err = node.Handle(msg)
+	if err != nil {
+		httperr(w, http.StatusServiceUnavailable, "handling protocol message: %s", err)
+		return
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
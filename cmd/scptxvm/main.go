@@ -116,19 +116,22 @@ func main() {
 	pubKey := prv.Public().(ed25519.PublicKey)
 	pubKeyHex := hex.EncodeToString(pubKey)
 
-	// xxx this is ugly!
-	ext := map[scp.SlotID]*scp.ExtTopic{
-		scp.SlotID(chain.Height()): &scp.ExtTopic{
-			C: scp.Ballot{
-				N: 1,
-				X: valtype(block.Hash()),
-			},
-			HN: 1,
+	// The node needs the value of the latest block in order to work on
+	// the next one.
+	ext := scp.NewMemExtStore(100)
+	err = ext.Put(scp.SlotID(chain.Height()), &scp.ExtTopic{
+		C: scp.Ballot{
+			N: 1,
+			X: valtype(block.Hash()),
 		},
+		HN: 1,
+	})
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	nodeID := fmt.Sprintf("http://%s/%s", conf.Addr, pubKeyHex)
-	node = scp.NewNode(scp.NodeID(nodeID), conf.Q, msgChan, ext)
+	node = scp.NewNode(scp.NodeID(nodeID), conf.Q, msgChan, scp.WithExtStore(ext), scp.WithLogger(scp.StdLogger{Min: scp.LevelInfo}), scp.WithConfig(scp.NodeConfig{Overflow: scp.OverflowError}))
 
 	go func() {
 		node.Run(bgctx)
@@ -225,7 +228,10 @@ func subscribe(ctx context.Context) {
 							continue
 						}
 						node.Logf("* sending %s to node.Handle", msg)
-						node.Handle(msg)
+						err = node.Handle(msg)
+						if err != nil {
+							node.Logf("ERROR: handling protocol message: %s", err)
+						}
 					}
 				}
 			}
@@ -65,13 +65,9 @@ func nominate(ctx context.Context) {
 		if err != nil {
 			return err
 		}
-		n := &scp.NomTopic{
-			X: scp.ValueSet{valtype(block.Hash())},
-		}
 		node.Logf("nominating block %x (%d tx(s)) at height %d", block.Hash().Bytes(), len(block.Transactions), block.Height)
-		msg := scp.NewMsg(node.ID, scp.SlotID(block.Height), node.Q, n) // xxx slotID is 32 bits, block height is 64
-		node.Handle(msg)
-		return nil
+		_, err = node.Nominate(ctx, scp.SlotID(block.Height), valtype(block.Hash())) // xxx slotID is 32 bits, block height is 64
+		return err
 	}
 
 	for {
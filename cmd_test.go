@@ -0,0 +1,283 @@
+package scp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCmdQueuePriority(t *testing.T) {
+	q := newCmdQueue(10, OverflowBlock)
+	q.writeLo(&delayCmd{ms: 1})
+	q.writeLo(&delayCmd{ms: 2})
+	q.writeHi(&delayCmd{ms: 3})
+	q.writeHi(&delayCmd{ms: 4})
+
+	if got := q.stats(); got.Depth != 2 || got.Internal != 2 {
+		t.Errorf("got stats %+v, want depth 2, internal 2", got)
+	}
+
+	var got []int
+	for {
+		cmd, ok := q.tryRead()
+		if !ok {
+			break
+		}
+		got = append(got, cmd.(*delayCmd).ms)
+	}
+	want := []int{3, 4, 1, 2}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestCmdQueueOverflow(t *testing.T) {
+	cases := []struct {
+		policy       OverflowPolicy
+		wantErr      error
+		wantCmds     []int
+		wantDropped  uint64
+		wantRejected uint64
+	}{
+		{
+			policy:       OverflowError,
+			wantErr:      ErrQueueFull,
+			wantCmds:     []int{1, 2},
+			wantRejected: 1,
+		},
+		{
+			policy:      OverflowDropOldest,
+			wantCmds:    []int{2, 3},
+			wantDropped: 1,
+		},
+	}
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%02d", i+1), func(t *testing.T) {
+			q := newCmdQueue(2, tc.policy)
+			for ms := 1; ms <= 3; ms++ {
+				err := q.writeLo(&delayCmd{ms: ms})
+				if ms < 3 && err != nil {
+					t.Fatal(err)
+				}
+				if ms == 3 && !errors.Is(err, tc.wantErr) {
+					t.Errorf("got error %v, want %v", err, tc.wantErr)
+				}
+			}
+
+			// Internal commands are never refused.
+			q.writeHi(&delayCmd{ms: 4})
+			cmd, _ := q.tryRead()
+			if cmd.(*delayCmd).ms != 4 {
+				t.Errorf("got %d first, want 4", cmd.(*delayCmd).ms)
+			}
+
+			var got []int
+			for {
+				cmd, ok := q.tryRead()
+				if !ok {
+					break
+				}
+				got = append(got, cmd.(*delayCmd).ms)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tc.wantCmds) {
+				t.Errorf("got %v, want %v", got, tc.wantCmds)
+			}
+			st := q.stats()
+			if st.Dropped != tc.wantDropped || st.Rejected != tc.wantRejected || st.MaxDepth != 2 {
+				t.Errorf("got stats %+v", st)
+			}
+		})
+	}
+
+	t.Run("block", func(t *testing.T) {
+		q := newCmdQueue(2, OverflowBlock)
+		q.writeLo(&delayCmd{ms: 1})
+		q.writeLo(&delayCmd{ms: 2})
+		done := make(chan struct{})
+		go func() {
+			q.writeLo(&delayCmd{ms: 3})
+			close(done)
+		}()
+		select {
+		case <-done:
+			t.Fatal("write did not block")
+		case <-time.After(10 * time.Millisecond):
+		}
+		q.tryRead()
+		<-done
+		if st := q.stats(); st.Depth != 2 || st.Dropped != 0 || st.Rejected != 0 {
+			t.Errorf("got stats %+v", st)
+		}
+
+		// A blocked write gives up when the reader goes away.
+		errCh := make(chan error)
+		go func() {
+			errCh <- q.writeLo(&delayCmd{ms: 4})
+		}()
+		select {
+		case err := <-errCh:
+			t.Fatalf("write did not block (error %v)", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+		q.stop()
+		if err := <-errCh; !errors.Is(err, ErrStopped) {
+			t.Errorf("got error %v, want %s", err, ErrStopped)
+		}
+	})
+}
+
+func TestHandleAfterRun(t *testing.T) {
+	ch := make(chan *Msg, 10)
+	q := QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("y")}}}
+	yQ := QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("x")}}}
+	n := NewNode("x", q, ch, WithConfig(NodeConfig{QueueCapacity: 1, Overflow: OverflowBlock}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		n.Run(ctx)
+		close(done)
+	}()
+	cancel()
+	<-done
+
+	// With nothing to make room in the queue, Handle would otherwise
+	// wait forever on the second message.
+	for i := 0; i < 2; i++ {
+		err := n.Handle(NewMsg("y", 1, yQ, &NomTopic{X: ValueSet{valtype(i)}}))
+		if !errors.Is(err, ErrStopped) {
+			t.Errorf("message %d: got error %v, want %s", i+1, err, ErrStopped)
+		}
+	}
+}
+
+func TestCmdQueueReadCanceled(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	q := newCmdQueue(10, OverflowBlock)
+	for i := 0; i < 100; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, ok := q.read(ctx); ok {
+			t.Fatal("read succeeded on an empty queue")
+		}
+	}
+
+	// Reads on a canceled context leave nothing behind.
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("got %d goroutines, want %d", after, before)
+	}
+
+	// A blocked read wakes for either kind of command.
+	for _, hi := range []bool{false, true} {
+		got := make(chan Cmd)
+		go func() {
+			cmd, _ := q.read(context.Background())
+			got <- cmd
+		}()
+		if hi {
+			q.writeHi(&delayCmd{ms: 2})
+		} else {
+			q.writeLo(&delayCmd{ms: 1})
+		}
+		if cmd := <-got; cmd == nil {
+			t.Error("read returned nothing")
+		}
+	}
+}
+
+func BenchmarkCmdQueue(b *testing.B) {
+	for _, policy := range []OverflowPolicy{OverflowBlock, OverflowDropOldest, OverflowError} {
+		b.Run(policy.String(), func(b *testing.B) {
+			q := newCmdQueue(1024, policy)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					if _, ok := q.read(ctx); !ok {
+						return
+					}
+				}
+			}()
+
+			cmd := &delayCmd{}
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					q.writeLo(cmd)
+				}
+			})
+			cancel()
+			wg.Wait()
+
+			st := q.stats()
+			b.ReportMetric(float64(st.MaxDepth), "maxdepth")
+			b.ReportMetric(float64(st.Dropped+st.Rejected)/float64(b.N), "lost/op")
+		})
+	}
+}
+
+// Many peers flooding a running node with messages.
+func BenchmarkHandleLoad(b *testing.B) {
+	var peers []NodeIDSet
+	for i := 0; i < 20; i++ {
+		peers = append(peers, NodeIDSet{NodeID(fmt.Sprintf("p%02d", i))})
+	}
+	q := slicesToQSet(peers)
+	peerQ := QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("x")}}}
+
+	for _, policy := range []OverflowPolicy{OverflowBlock, OverflowDropOldest, OverflowError} {
+		b.Run(policy.String(), func(b *testing.B) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			ch := make(chan *Msg, 1024)
+			go func() {
+				for range ch {
+				}
+			}()
+			defer close(ch)
+
+			n := NewNode("x", q, ch, WithConfig(NodeConfig{QueueCapacity: 256, Overflow: policy}))
+			done := make(chan struct{})
+			go func() {
+				n.Run(ctx)
+				close(done)
+			}()
+
+			var (
+				mu   sync.Mutex
+				next int
+			)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					mu.Lock()
+					k := next
+					next++
+					mu.Unlock()
+					peer := peers[k%len(peers)][0]
+					msg := NewMsg(peer, 1, peerQ, &NomTopic{X: ValueSet{valtype(k)}})
+					n.Handle(msg)
+				}
+			})
+
+			// Wait for the node to catch up.
+			for n.QueueStats().Depth > 0 {
+				runtime.Gosched()
+			}
+			cancel()
+			<-done
+
+			st := n.QueueStats()
+			b.ReportMetric(float64(st.MaxDepth), "maxdepth")
+			b.ReportMetric(float64(st.Dropped+st.Rejected)/float64(b.N), "lost/op")
+		})
+	}
+}
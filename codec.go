@@ -0,0 +1,607 @@
+package scp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/davecgh/go-xdr/xdr2"
+)
+
+// ValueCodec converts Values to and from bytes for the binary wire
+// encoding (see MarshalMsg). Each Value must have exactly one
+// encoding, and DecodeValue(EncodeValue(v)) must equal v.
+type ValueCodec interface {
+	EncodeValue(Value) ([]byte, error)
+	DecodeValue([]byte) (Value, error)
+}
+
+// The binary wire encoding of messages and their parts is XDR (RFC
+// 4506), as follows:
+//
+//   struct Envelope {
+//     Msg    M;
+//     int    C;
+//     opaque S<>; // empty for unsigned messages
+//   };
+//
+//   struct Msg {
+//     string V;
+//     hyper  I;
+//     QSet   Q;
+//     Topic  T;
+//   };
+//
+//   struct QSet {
+//     unsigned int T;
+//     QSetMember   M<>;
+//   };
+//
+//   union QSetMember switch (unsigned int kind) {
+//     case 0: string N;
+//     case 1: QSet   Q;
+//   };
+//
+//   struct Ballot {
+//     unsigned int N;
+//     opaque       X<>*; // nil Values are absent
+//   };
+//
+//   union Topic switch (unsigned int kind) {
+//     case 0: struct { Value X<>; Value Y<>; } nom;
+//     case 1: struct { Value X<>; Value Y<>;
+//                      Ballot B; Ballot P; Ballot PP; unsigned int HN; unsigned int CN; } nomprep;
+//     case 2: struct { Ballot B; Ballot P; Ballot PP; unsigned int HN; unsigned int CN; } prep;
+//     case 3: struct { Ballot B; unsigned int PN; unsigned int HN; unsigned int CN; } commit;
+//     case 4: struct { Ballot C; unsigned int HN; } ext;
+//   };
+//
+// where each Value is opaque<> as produced by a ValueCodec, and each
+// value set is in strictly increasing order. A message is signed over
+// the encoding of its Msg part alone (see SigningBytes), and sent as
+// an Envelope carrying that, the envelope counter Msg.C, and the
+// signature Msg.S.
+//
+// Decoding rejects any input that is not exactly the encoding of its
+// result, so every message has a single valid byte representation.
+
+const (
+	topicNom uint32 = iota
+	topicNomPrep
+	topicPrep
+	topicCommit
+	topicExt
+)
+
+const (
+	memberNode uint32 = iota
+	memberQSet
+)
+
+// maxQSetDepth is the maximum nesting depth of a QSet: the top level
+// plus this many levels of inner QSets.
+const maxQSetDepth = 4
+
+var errNonCanonical = errors.New("non-canonical encoding")
+
+// MarshalMsg produces the binary wire encoding of msg, including its
+// envelope counter and signature.
+func MarshalMsg(msg *Msg, vc ValueCodec) ([]byte, error) {
+	w := newXDRWriter(vc)
+	w.envelope(msg)
+	return w.result()
+}
+
+// UnmarshalMsg decodes a message from its binary wire encoding.
+func UnmarshalMsg(b []byte, vc ValueCodec) (*Msg, error) {
+	var msg *Msg
+	err := unmarshal(b, vc, func(r *xdrReader) { msg = r.envelope() }, func(w *xdrWriter) { w.envelope(msg) })
+	return msg, err
+}
+
+// Produces the encoding of msg without its envelope counter and
+// signature.
+func marshalMsgBody(msg *Msg, vc ValueCodec) ([]byte, error) {
+	w := newXDRWriter(vc)
+	w.msg(msg)
+	return w.result()
+}
+
+// MarshalQSet produces the binary wire encoding of q.
+func MarshalQSet(q QSet) ([]byte, error) {
+	w := newXDRWriter(nil)
+	w.qset(q, 0)
+	return w.result()
+}
+
+// UnmarshalQSet decodes a QSet from its binary wire encoding.
+func UnmarshalQSet(b []byte) (QSet, error) {
+	var q QSet
+	err := unmarshal(b, nil, func(r *xdrReader) { q = r.qset(0) }, func(w *xdrWriter) { w.qset(q, 0) })
+	return q, err
+}
+
+// MarshalBallot produces the binary wire encoding of b.
+func MarshalBallot(b Ballot, vc ValueCodec) ([]byte, error) {
+	w := newXDRWriter(vc)
+	w.ballot(b)
+	return w.result()
+}
+
+// UnmarshalBallot decodes a ballot from its binary wire encoding.
+func UnmarshalBallot(b []byte, vc ValueCodec) (Ballot, error) {
+	var ballot Ballot
+	err := unmarshal(b, vc, func(r *xdrReader) { ballot = r.ballot() }, func(w *xdrWriter) { w.ballot(ballot) })
+	return ballot, err
+}
+
+// MarshalTopic produces the binary wire encoding of t, which must be
+// a *NomTopic, *NomPrepTopic, *PrepTopic, *CommitTopic, or *ExtTopic.
+func MarshalTopic(t Topic, vc ValueCodec) ([]byte, error) {
+	w := newXDRWriter(vc)
+	w.topic(t)
+	return w.result()
+}
+
+// UnmarshalTopic decodes a topic from its binary wire encoding.
+func UnmarshalTopic(b []byte, vc ValueCodec) (Topic, error) {
+	var t Topic
+	err := unmarshal(b, vc, func(r *xdrReader) { t = r.topic() }, func(w *xdrWriter) { w.topic(t) })
+	return t, err
+}
+
+// Decodes b with read,
+// checks that all of b was consumed,
+// and checks that re-encoding the result with write reproduces b exactly.
+func unmarshal(b []byte, vc ValueCodec, read func(*xdrReader), write func(*xdrWriter)) error {
+	r := newXDRReader(b, vc)
+	read(r)
+	if r.err != nil {
+		return r.err
+	}
+	if r.buf.Len() > 0 {
+		return fmt.Errorf("%d trailing byte(s): %w", r.buf.Len(), errNonCanonical)
+	}
+	w := newXDRWriter(vc)
+	write(w)
+	b2, err := w.result()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(b, b2) {
+		return errNonCanonical
+	}
+	return nil
+}
+
+// Encodes SCP types as XDR.
+// After the first error,
+// all further writes are no-ops.
+type xdrWriter struct {
+	buf bytes.Buffer
+	enc *xdr.Encoder
+	vc  ValueCodec
+	err error
+}
+
+func newXDRWriter(vc ValueCodec) *xdrWriter {
+	w := &xdrWriter{vc: vc}
+	w.enc = xdr.NewEncoder(&w.buf)
+	return w
+}
+
+func (w *xdrWriter) result() ([]byte, error) {
+	if w.err != nil {
+		return nil, w.err
+	}
+	return w.buf.Bytes(), nil
+}
+
+func (w *xdrWriter) uint(n int) {
+	if w.err != nil {
+		return
+	}
+	if n < 0 || n > math.MaxInt32 {
+		w.err = fmt.Errorf("integer %d out of range", n)
+		return
+	}
+	_, w.err = w.enc.EncodeUint(uint32(n))
+}
+
+func (w *xdrWriter) kind(k uint32) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = w.enc.EncodeUint(k)
+}
+
+func (w *xdrWriter) string(s string) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = w.enc.EncodeString(s)
+}
+
+func (w *xdrWriter) envelope(msg *Msg) {
+	w.msg(msg)
+	if w.err == nil {
+		_, w.err = w.enc.EncodeInt(msg.C)
+	}
+	if w.err == nil {
+		_, w.err = w.enc.EncodeOpaque(msg.S)
+	}
+}
+
+func (w *xdrWriter) msg(msg *Msg) {
+	w.string(string(msg.V))
+	if w.err == nil {
+		_, w.err = w.enc.EncodeHyper(int64(msg.I))
+	}
+	w.qset(msg.Q, 0)
+	w.topic(msg.T)
+}
+
+func (w *xdrWriter) qset(q QSet, depth int) {
+	if w.err != nil {
+		return
+	}
+	if depth > maxQSetDepth {
+		w.err = fmt.Errorf("QSet nested more than %d levels deep", maxQSetDepth)
+		return
+	}
+	w.uint(q.T)
+	w.uint(len(q.M))
+	for _, m := range q.M {
+		switch {
+		case m.N != nil && m.Q == nil:
+			w.kind(memberNode)
+			w.string(string(*m.N))
+
+		case m.Q != nil && m.N == nil:
+			w.kind(memberQSet)
+			w.qset(*m.Q, depth+1)
+
+		default:
+			if w.err == nil {
+				w.err = errors.New("QSet member must have exactly one of N and Q")
+			}
+		}
+	}
+}
+
+func (w *xdrWriter) value(v Value) {
+	if w.err != nil {
+		return
+	}
+	if w.vc == nil {
+		w.err = errors.New("no ValueCodec")
+		return
+	}
+	b, err := w.vc.EncodeValue(v)
+	if err != nil {
+		w.err = err
+		return
+	}
+	_, w.err = w.enc.EncodeOpaque(b)
+}
+
+func (w *xdrWriter) valueSet(vs ValueSet) {
+	w.uint(len(vs))
+	for i, v := range vs {
+		if w.err != nil {
+			return
+		}
+		if isNilVal(v) {
+			w.err = errors.New("nil value in value set")
+			return
+		}
+		if i > 0 && !vs[i-1].Less(v) {
+			w.err = errors.New("value set is not in strictly increasing order")
+			return
+		}
+		w.value(v)
+	}
+}
+
+func (w *xdrWriter) ballot(b Ballot) {
+	w.uint(b.N)
+	if w.err != nil {
+		return
+	}
+	present := !isNilVal(b.X)
+	_, w.err = w.enc.EncodeBool(present)
+	if present {
+		w.value(b.X)
+	}
+}
+
+func (w *xdrWriter) prep(topic *PrepTopic) {
+	w.ballot(topic.B)
+	w.ballot(topic.P)
+	w.ballot(topic.PP)
+	w.uint(topic.HN)
+	w.uint(topic.CN)
+}
+
+func (w *xdrWriter) topic(t Topic) {
+	switch topic := t.(type) {
+	case *NomTopic:
+		w.kind(topicNom)
+		w.valueSet(topic.X)
+		w.valueSet(topic.Y)
+
+	case *NomPrepTopic:
+		w.kind(topicNomPrep)
+		w.valueSet(topic.X)
+		w.valueSet(topic.Y)
+		w.prep(&topic.PrepTopic)
+
+	case *PrepTopic:
+		w.kind(topicPrep)
+		w.prep(topic)
+
+	case *CommitTopic:
+		w.kind(topicCommit)
+		w.ballot(topic.B)
+		w.uint(topic.PN)
+		w.uint(topic.HN)
+		w.uint(topic.CN)
+
+	case *ExtTopic:
+		w.kind(topicExt)
+		w.ballot(topic.C)
+		w.uint(topic.HN)
+
+	default:
+		if w.err == nil {
+			w.err = fmt.Errorf("cannot encode topic of type %T", t)
+		}
+	}
+}
+
+// Decodes SCP types from XDR.
+// After the first error,
+// all further reads are no-ops returning zero values.
+type xdrReader struct {
+	buf *bytes.Reader
+	dec *xdr.Decoder
+	vc  ValueCodec
+	err error
+}
+
+func newXDRReader(b []byte, vc ValueCodec) *xdrReader {
+	buf := bytes.NewReader(b)
+	return &xdrReader{
+		buf: buf,
+		dec: xdr.NewDecoderLimited(buf, uint(len(b))),
+		vc:  vc,
+	}
+}
+
+func (r *xdrReader) uint() int {
+	if r.err != nil {
+		return 0
+	}
+	n, _, err := r.dec.DecodeUint()
+	if err != nil {
+		r.err = err
+		return 0
+	}
+	if n > math.MaxInt32 {
+		r.err = fmt.Errorf("integer %d out of range", n)
+		return 0
+	}
+	return int(n)
+}
+
+func (r *xdrReader) kind() uint32 {
+	if r.err != nil {
+		return 0
+	}
+	k, _, err := r.dec.DecodeUint()
+	r.err = err
+	return k
+}
+
+func (r *xdrReader) string() string {
+	if r.err != nil {
+		return ""
+	}
+	s, _, err := r.dec.DecodeString()
+	r.err = err
+	return s
+}
+
+// Reads an array length, sanity-checking it against the remaining input
+// (every array element takes at least 4 bytes).
+func (r *xdrReader) len() int {
+	n := r.uint()
+	if r.err == nil && n > r.buf.Len()/4 {
+		r.err = fmt.Errorf("array length %d exceeds remaining input", n)
+		return 0
+	}
+	return n
+}
+
+func (r *xdrReader) envelope() *Msg {
+	msg := r.msg()
+	var c int32
+	if r.err == nil {
+		c, _, r.err = r.dec.DecodeInt()
+	}
+	var sig []byte
+	if r.err == nil {
+		sig, _, r.err = r.dec.DecodeOpaque()
+	}
+	if r.err != nil {
+		return nil
+	}
+	msg.C = c
+	if len(sig) > 0 {
+		msg.S = sig
+	}
+	return msg
+}
+
+func (r *xdrReader) msg() *Msg {
+	v := NodeID(r.string())
+	var i SlotID
+	if r.err == nil {
+		var n int64
+		n, _, r.err = r.dec.DecodeHyper()
+		i = SlotID(n)
+	}
+	q := r.qset(0)
+	t := r.topic()
+	if r.err != nil {
+		return nil
+	}
+	return NewMsg(v, i, q, t)
+}
+
+func (r *xdrReader) qset(depth int) QSet {
+	if r.err != nil {
+		return QSet{}
+	}
+	if depth > maxQSetDepth {
+		r.err = fmt.Errorf("QSet nested more than %d levels deep", maxQSetDepth)
+		return QSet{}
+	}
+	var q QSet
+	q.T = r.uint()
+	n := r.len()
+	for i := 0; i < n && r.err == nil; i++ {
+		switch k := r.kind(); k {
+		case memberNode:
+			id := NodeID(r.string())
+			q.M = append(q.M, QSetMember{N: &id})
+
+		case memberQSet:
+			inner := r.qset(depth + 1)
+			q.M = append(q.M, QSetMember{Q: &inner})
+
+		default:
+			if r.err == nil {
+				r.err = fmt.Errorf("unknown QSet member kind %d", k)
+			}
+		}
+	}
+	return q
+}
+
+func (r *xdrReader) value() Value {
+	if r.err != nil {
+		return nil
+	}
+	if r.vc == nil {
+		r.err = errors.New("no ValueCodec")
+		return nil
+	}
+	b, _, err := r.dec.DecodeOpaque()
+	if err != nil {
+		r.err = err
+		return nil
+	}
+	v, err := r.vc.DecodeValue(b)
+	if err != nil {
+		r.err = err
+		return nil
+	}
+	if isNilVal(v) {
+		r.err = errors.New("decoded nil value")
+		return nil
+	}
+	return v
+}
+
+func (r *xdrReader) valueSet() ValueSet {
+	n := r.len()
+	var result ValueSet
+	for i := 0; i < n && r.err == nil; i++ {
+		v := r.value()
+		if r.err != nil {
+			break
+		}
+		if i > 0 && !result[i-1].Less(v) {
+			r.err = fmt.Errorf("value set is not in strictly increasing order: %w", errNonCanonical)
+			break
+		}
+		result = append(result, v)
+	}
+	return result
+}
+
+func (r *xdrReader) ballot() Ballot {
+	var b Ballot
+	b.N = r.uint()
+	if r.err != nil {
+		return b
+	}
+	present, _, err := r.dec.DecodeBool()
+	if err != nil {
+		r.err = err
+		return b
+	}
+	if present {
+		b.X = r.value()
+	}
+	return b
+}
+
+func (r *xdrReader) prep() PrepTopic {
+	var topic PrepTopic
+	topic.B = r.ballot()
+	topic.P = r.ballot()
+	topic.PP = r.ballot()
+	topic.HN = r.uint()
+	topic.CN = r.uint()
+	return topic
+}
+
+func (r *xdrReader) topic() Topic {
+	k := r.kind()
+	if r.err != nil {
+		return nil
+	}
+	var result Topic
+	switch k {
+	case topicNom:
+		topic := new(NomTopic)
+		topic.X = r.valueSet()
+		topic.Y = r.valueSet()
+		result = topic
+
+	case topicNomPrep:
+		topic := new(NomPrepTopic)
+		topic.X = r.valueSet()
+		topic.Y = r.valueSet()
+		topic.PrepTopic = r.prep()
+		result = topic
+
+	case topicPrep:
+		topic := r.prep()
+		result = &topic
+
+	case topicCommit:
+		topic := new(CommitTopic)
+		topic.B = r.ballot()
+		topic.PN = r.uint()
+		topic.HN = r.uint()
+		topic.CN = r.uint()
+		result = topic
+
+	case topicExt:
+		topic := new(ExtTopic)
+		topic.C = r.ballot()
+		topic.HN = r.uint()
+		result = topic
+
+	default:
+		r.err = fmt.Errorf("unknown topic kind %d", k)
+	}
+	if r.err != nil {
+		return nil
+	}
+	return result
+}
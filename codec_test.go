@@ -0,0 +1,139 @@
+package scp
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+type valtypeCodec struct{}
+
+func (valtypeCodec) EncodeValue(v Value) ([]byte, error) {
+	return v.Bytes(), nil
+}
+
+func (valtypeCodec) DecodeValue(b []byte) (Value, error) {
+	if len(b) != 4 {
+		return nil, fmt.Errorf("got %d bytes, want 4", len(b))
+	}
+	return valtype(binary.BigEndian.Uint32(b)), nil
+}
+
+func TestMsgRoundTrip(t *testing.T) {
+	q := QSet{
+		T: 2,
+		M: []QSetMember{
+			{N: nodeIDPtr("a")},
+			{N: nodeIDPtr("b")},
+			{Q: &QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("c")}, {N: nodeIDPtr("d")}}}},
+		},
+	}
+	cases := []Topic{
+		&NomTopic{},
+		&NomTopic{X: ValueSet{valtype(1), valtype(2)}, Y: ValueSet{valtype(3)}},
+		&NomPrepTopic{
+			NomTopic:  NomTopic{X: ValueSet{valtype(1)}},
+			PrepTopic: PrepTopic{B: Ballot{1, valtype(1)}},
+		},
+		&PrepTopic{B: Ballot{3, valtype(5)}, P: Ballot{2, valtype(5)}, PP: Ballot{1, valtype(4)}, HN: 2, CN: 1},
+		&CommitTopic{B: Ballot{3, valtype(5)}, PN: 3, HN: 3, CN: 2},
+		&ExtTopic{C: Ballot{1, valtype(5)}, HN: 2147483647},
+	}
+	for i, topic := range cases {
+		t.Run(fmt.Sprintf("%02d", i+1), func(t *testing.T) {
+			msg := NewMsg("x", 7, q, topic)
+			if i%2 == 1 {
+				msg.S = []byte{1, 2, 3, 4, 5}
+			}
+			b, err := MarshalMsg(msg, valtypeCodec{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			b2, err := MarshalMsg(msg, valtypeCodec{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(b, b2) {
+				t.Fatal("encoding is not deterministic")
+			}
+			got, err := UnmarshalMsg(b, valtypeCodec{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.C != msg.C || got.V != msg.V || got.I != msg.I {
+				t.Errorf("got C=%d V=%s I=%d, want C=%d V=%s I=%d", got.C, got.V, got.I, msg.C, msg.V, msg.I)
+			}
+			if !reflect.DeepEqual(got.Q, msg.Q) {
+				t.Errorf("got Q %v, want %v", got.Q, msg.Q)
+			}
+			if !reflect.DeepEqual(got.T, msg.T) {
+				t.Errorf("got T %s, want %s", got.T, msg.T)
+			}
+			if !reflect.DeepEqual(got.S, msg.S) {
+				t.Errorf("got S %x, want %x", got.S, msg.S)
+			}
+		})
+	}
+}
+
+func TestMarshalMsgVector(t *testing.T) {
+	msg := NewMsg("x", 1, QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("y")}}}, &ExtTopic{C: Ballot{1, valtype(7)}, HN: 1})
+	msg.C = 3
+	msg.S = []byte{0xab, 0xcd}
+	b, err := MarshalMsg(msg, valtypeCodec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = "00000001" + "78000000" + // V
+		"0000000000000001" + // I
+		"00000001" + "00000001" + "00000000" + "00000001" + "79000000" + // Q
+		"00000004" + // EXT
+		"00000001" + "00000001" + "00000004" + "00000007" + // C
+		"00000001" + // HN
+		"00000003" + // envelope counter
+		"00000002" + "abcd0000" // signature
+	if got := hex.EncodeToString(b); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestUnmarshalRejects(t *testing.T) {
+	ballot, err := MarshalBallot(Ballot{1, valtype(7)}, valtypeCodec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = UnmarshalBallot(append(ballot, 0, 0, 0, 0), valtypeCodec{}); !errors.Is(err, errNonCanonical) {
+		t.Errorf("trailing bytes: got error %v, want %s", err, errNonCanonical)
+	}
+
+	// A NOMINATE topic with X in decreasing order.
+	nom, err := hex.DecodeString("00000000" + "00000002" + "0000000400000002" + "0000000400000001" + "00000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = UnmarshalTopic(nom, valtypeCodec{}); !errors.Is(err, errNonCanonical) {
+		t.Errorf("unsorted value set: got error %v, want %s", err, errNonCanonical)
+	}
+
+	// A string with nonzero padding.
+	q, err := hex.DecodeString("00000001" + "00000001" + "00000000" + "00000001" + "79000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = UnmarshalQSet(q); !errors.Is(err, errNonCanonical) {
+		t.Errorf("bad padding: got error %v, want %s", err, errNonCanonical)
+	}
+
+	// Nesting too deep.
+	deep := QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("a")}}}
+	for i := 0; i <= maxQSetDepth; i++ {
+		inner := deep
+		deep = QSet{T: 1, M: []QSetMember{{Q: &inner}}}
+	}
+	if _, err = MarshalQSet(deep); err == nil {
+		t.Error("got no error encoding deeply nested QSet")
+	}
+}
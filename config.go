@@ -0,0 +1,226 @@
+package scp
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// NodeConfig holds the tunable parameters of a Node. Each node has its
+// own copy, so nodes in the same process may be configured
+// differently.
+type NodeConfig struct {
+	// NomRoundInterval determines the duration of a nomination
+	// "round." Unless RoundDuration is set, round N lasts for a
+	// duration of (2+N)*NomRoundInterval. A node's neighbor set
+	// changes from one round to the next, as do the priorities of the
+	// peers in that set.
+	NomRoundInterval time.Duration
+
+	// RoundDuration, if non-nil, gives the duration of nomination
+	// round r (where the first round is 1), overriding the formula
+	// based on NomRoundInterval. It should return a positive
+	// duration; a round for which it doesn't lasts NomRoundInterval
+	// instead.
+	RoundDuration func(r int) time.Duration
+
+	// DeferredUpdateInterval determines the delay between arming a
+	// deferred-update timer and firing it. The delay is
+	// (1+N)*DeferredUpdateInterval, where N is the value of the slot's
+	// ballot counter (B.N).
+	DeferredUpdateInterval time.Duration
+
+	// A slot's ballot counter must always be less than
+	// BallotCounterBase plus the number of BallotCounterIntervals that
+	// have elapsed since the slot was created. Since a zero
+	// BallotCounterBase takes the default, a base of zero is requested
+	// with a negative value.
+	BallotCounterBase     int
+	BallotCounterInterval time.Duration
+
+	// A message for slot i can't be processed until slot i-1 has
+	// externalized. Until then it is buffered, provided i is no more
+	// than FutureSlotWindow slots beyond the highest externalized
+	// slot. Messages for slots beyond the window are rejected.
+	FutureSlotWindow int
+
+	// MaxFutureMsgs limits the number of messages buffered for future
+	// slots. Only the latest message from each sender for each slot is
+	// kept. When the buffer is full, new messages are rejected.
+	MaxFutureMsgs int
+
+	// QueueCapacity is the number of peer messages that may wait in a
+	// node's queue to be processed. Overflow says what Node.Handle
+	// does when the queue is full. (Internal events, such as timers
+	// firing, are queued separately, without limit, and are processed
+	// first.)
+	QueueCapacity int
+	Overflow      OverflowPolicy
+
+	// While a node has pending slots, every RebroadcastInterval it
+	// sends its latest message for each of them again, along with the
+	// EXTERNALIZE messages for its RebroadcastExt highest
+	// externalized slots. This lets the network recover from lost
+	// messages. When the node has sent new messages since the last
+	// rebroadcast, it skips rebroadcasting and doubles the interval,
+	// up to MaxRebroadcastInterval. A negative RebroadcastInterval
+	// disables rebroadcasting, and a negative RebroadcastExt disables
+	// rebroadcasting EXTERNALIZE messages.
+	RebroadcastInterval    time.Duration
+	MaxRebroadcastInterval time.Duration
+	RebroadcastExt         int
+}
+
+// DefaultNodeConfig is the configuration used by nodes not given
+// WithConfig.
+var DefaultNodeConfig = NodeConfig{
+	NomRoundInterval:       time.Second,
+	DeferredUpdateInterval: time.Second,
+	BallotCounterBase:      1000,
+	BallotCounterInterval:  time.Second,
+	FutureSlotWindow:       4,
+	MaxFutureMsgs:          1000,
+	QueueCapacity:          1024,
+	Overflow:               OverflowBlock,
+	RebroadcastInterval:    2 * time.Second,
+	MaxRebroadcastInterval: time.Minute,
+	RebroadcastExt:         1,
+}
+
+// WithConfig sets a node's parameters. Zero-valued fields in
+// cfg take their values from DefaultNodeConfig, as it is when the
+// option is applied. WithConfig panics if cfg is invalid (see
+// Validate).
+func WithConfig(cfg NodeConfig) NodeOption {
+	return func(n *Node) {
+		var err error
+		n.cfg, err = cfg.withDefaults()
+		if err != nil {
+			panic(fmt.Sprintf("scp.WithConfig: %s", err))
+		}
+	}
+}
+
+// Validate checks that none of the intervals in cfg is negative,
+// apart from RebroadcastInterval, for which a negative value disables
+// rebroadcasting.
+func (cfg NodeConfig) Validate() error {
+	intervals := []struct {
+		name string
+		d    time.Duration
+	}{
+		{"NomRoundInterval", cfg.NomRoundInterval},
+		{"DeferredUpdateInterval", cfg.DeferredUpdateInterval},
+		{"BallotCounterInterval", cfg.BallotCounterInterval},
+		{"MaxRebroadcastInterval", cfg.MaxRebroadcastInterval},
+	}
+	for _, iv := range intervals {
+		if iv.d < 0 {
+			return fmt.Errorf("negative %s %s", iv.name, iv.d)
+		}
+	}
+	return nil
+}
+
+// Produces a copy of cfg with its defaults filled in, so that nothing
+// needs DefaultNodeConfig afterwards.
+func (cfg NodeConfig) withDefaults() (NodeConfig, error) {
+	err := cfg.Validate()
+	if err != nil {
+		return cfg, err
+	}
+	if cfg.NomRoundInterval == 0 {
+		cfg.NomRoundInterval = DefaultNodeConfig.NomRoundInterval
+	}
+	if cfg.DeferredUpdateInterval == 0 {
+		cfg.DeferredUpdateInterval = DefaultNodeConfig.DeferredUpdateInterval
+	}
+	switch {
+	case cfg.BallotCounterBase == 0:
+		cfg.BallotCounterBase = DefaultNodeConfig.BallotCounterBase
+	case cfg.BallotCounterBase < 0:
+		cfg.BallotCounterBase = 0
+	}
+	if cfg.BallotCounterInterval == 0 {
+		cfg.BallotCounterInterval = DefaultNodeConfig.BallotCounterInterval
+	}
+	if cfg.FutureSlotWindow == 0 {
+		cfg.FutureSlotWindow = DefaultNodeConfig.FutureSlotWindow
+	}
+	if cfg.MaxFutureMsgs == 0 {
+		cfg.MaxFutureMsgs = DefaultNodeConfig.MaxFutureMsgs
+	}
+	if cfg.QueueCapacity == 0 {
+		cfg.QueueCapacity = DefaultNodeConfig.QueueCapacity
+	}
+	if cfg.RebroadcastInterval == 0 {
+		cfg.RebroadcastInterval = DefaultNodeConfig.RebroadcastInterval
+	}
+	if cfg.MaxRebroadcastInterval == 0 {
+		cfg.MaxRebroadcastInterval = DefaultNodeConfig.MaxRebroadcastInterval
+	}
+	if cfg.RebroadcastExt == 0 {
+		cfg.RebroadcastExt = DefaultNodeConfig.RebroadcastExt
+	}
+	return cfg, nil
+}
+
+// Tells the duration of nomination round r.
+func (cfg NodeConfig) roundDuration(r int) time.Duration {
+	if cfg.RoundDuration != nil {
+		if d := cfg.RoundDuration(r); d > 0 {
+			return d
+		}
+		// A round must take some time, or round would loop forever.
+		return cfg.NomRoundInterval
+	}
+	return time.Duration(2+r) * cfg.NomRoundInterval
+}
+
+// Tells the nomination round in progress after elapsed time d.
+func (cfg NodeConfig) round(d time.Duration) int {
+	if d < 0 {
+		return 1
+	}
+	if cfg.RoundDuration == nil {
+		// Round r starts after (r-1)(r+4)/2 intervals (see roundStart).
+		// Solve for r, then correct for any floating-point error.
+		x := float64(d) / float64(cfg.NomRoundInterval)
+		r := 1 + int((math.Sqrt(25+8*x)-5)/2)
+		for r > 1 && cfg.roundStart(r) > d {
+			r--
+		}
+		for cfg.roundStart(r+1) <= d {
+			r++
+		}
+		return r
+	}
+	r := 1
+	for end := cfg.roundDuration(1); end <= d; end += cfg.roundDuration(r) {
+		r++
+	}
+	return r
+}
+
+// Tells the elapsed time at which nomination round r begins.
+func (cfg NodeConfig) roundStart(r int) time.Duration {
+	if cfg.RoundDuration == nil {
+		// The sum of (2+i) intervals for i from 1 to r-1.
+		return time.Duration((r-1)*(r+4)/2) * cfg.NomRoundInterval
+	}
+	var result time.Duration
+	for i := 1; i < r; i++ {
+		result += cfg.roundDuration(i)
+	}
+	return result
+}
+
+// Tells the highest ballot counter permitted after elapsed time d.
+func (cfg NodeConfig) maxBallotCounter(d time.Duration) int {
+	return cfg.BallotCounterBase + int(d/cfg.BallotCounterInterval)
+}
+
+// Tells the elapsed time after which ballot counter n is permitted.
+func (cfg NodeConfig) ballotCounterTime(n int) time.Duration {
+	return time.Duration(n-cfg.BallotCounterBase) * cfg.BallotCounterInterval
+}
@@ -2,10 +2,23 @@
 
 Package scp is an implementation of the Stellar Consensus Protocol.
 
-A Node is a participant in an SCP network. A caller feeds incoming
-protocol messages (type Msg) to the node's Handle method. In most
-cases, the node will respond with another Msg, which the caller should
-then disseminate to other network nodes.
+A Node is a participant in an SCP network. A caller proposes values
+with the node's Nominate method and feeds it protocol messages (type
+Msg) from its peers with the Handle method. In most cases, the node
+will respond with another Msg, which the caller should then
+disseminate to other network nodes. Externalized, WaitExternalized,
+and ExtValues report the values the node decides, and Status reports
+the state of its pending slots. These and the node's other query
+methods are safe to call while the node is running.
+
+Incoming messages wait in a bounded queue until the node can process
+them. The NodeConfig fields QueueCapacity and Overflow say how large
+the queue is and what Handle does when it's full; QueueStats reports
+the queue's depth and how many messages it has turned away.
+
+A node doesn't assume its messages are delivered. While it has
+pending slots and is making no progress, it periodically sends its
+latest messages again (see NodeConfig.RebroadcastInterval).
 
 The network votes on abstract Value objects proposed by its
 members. By means of the protocol, all participating nodes should
@@ -17,9 +30,30 @@ A caller may instantiate Value with any concrete type that can be
 totally ordered, and for which a deterministic, commutative Combine
 operation can be written (reducing two Values to a single one).
 
+MarshalMsg and UnmarshalMsg convert messages to and from a canonical
+binary encoding, with the help of a caller-supplied ValueCodec. A
+node given a Signer and a Verifier (see WithSigner and WithVerifier)
+signs the messages it sends and discards incoming messages whose
+signatures don't verify. Ed25519Signer and Ed25519Verifier implement
+these using Ed25519 keys.
+
+A node logs nothing unless given a Logger (see WithLogger). Its log
+entries carry structured fields, such as the node and slot IDs;
+StdLogger writes them through the standard log package.
+
+A node given an Observer (see WithObserver) reports the progress of
+each slot through nomination and balloting as it happens.
+
+QuorumIntersection, MinimalQuorums, NodeCriticality, and
+QSet.MinimalBlockingSets analyze the topology of a network given the
+quorum slices of all its nodes.
+
 A toy demo can be found in cmd/lunch. It takes the name of a TOML file
 as an argument. The TOML file specifies the network participants and
 topology. Sample TOML files are in cmd/lunch/toml.
 
+Package sim (in the sim subdirectory) runs networks of nodes
+deterministically on a virtual clock, for testing.
+
 */
 package scp
@@ -0,0 +1,247 @@
+package scp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ExtStore holds the values a node has externalized, as the
+// EXTERNALIZE message payloads that decided them. Implementations
+// must be safe for concurrent use.
+type ExtStore interface {
+	// Get returns the externalized payload for the given slot,
+	// or nil if there is none (or it is no longer retained).
+	Get(SlotID) (*ExtTopic, error)
+
+	// Put records the externalized payload for the given slot.
+	Put(SlotID, *ExtTopic) error
+
+	// Highest returns the ID of the highest slot that has been Put,
+	// or 0 if there is none.
+	Highest() SlotID
+
+	// Range calls f, in increasing slot order, for each retained
+	// slot ID in the range [from, to] (inclusive) until f returns
+	// false.
+	Range(from, to SlotID, f func(SlotID, *ExtTopic) bool) error
+}
+
+// MemExtStore is an in-memory ExtStore.
+type MemExtStore struct {
+	mu     sync.Mutex
+	retain int
+	ids    []SlotID // sorted
+	m      map[SlotID]*ExtTopic
+}
+
+// NewMemExtStore produces a new MemExtStore that retains only the
+// highest retain slots it is given. If retain is 0, all slots are
+// retained.
+//
+// Note that a node needs slot i-1 (see Node.G) to process slot i, and
+// any callers of Node.MsgsSince may need more. A node drops messages
+// for slots that it has externalized but no longer retains.
+func NewMemExtStore(retain int) *MemExtStore {
+	return &MemExtStore{
+		retain: retain,
+		m:      make(map[SlotID]*ExtTopic),
+	}
+}
+
+// Get implements ExtStore.Get.
+func (s *MemExtStore) Get(slotID SlotID) (*ExtTopic, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.m[slotID], nil
+}
+
+// Put implements ExtStore.Put.
+func (s *MemExtStore) Put(slotID SlotID, topic *ExtTopic) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.m[slotID]; !ok {
+		s.ids = insertSlotID(s.ids, slotID)
+	}
+	s.m[slotID] = topic
+	if s.retain > 0 {
+		for len(s.ids) > s.retain {
+			delete(s.m, s.ids[0])
+			s.ids = s.ids[1:]
+		}
+	}
+	return nil
+}
+
+// Highest implements ExtStore.Highest.
+func (s *MemExtStore) Highest() SlotID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.ids) == 0 {
+		return 0
+	}
+	return s.ids[len(s.ids)-1]
+}
+
+// Range implements ExtStore.Range.
+func (s *MemExtStore) Range(from, to SlotID, f func(SlotID, *ExtTopic) bool) error {
+	s.mu.Lock()
+	var (
+		ids    = slotIDRange(s.ids, from, to)
+		topics = make([]*ExtTopic, 0, len(ids))
+	)
+	for _, slotID := range ids {
+		topics = append(topics, s.m[slotID])
+	}
+	s.mu.Unlock()
+
+	for i, slotID := range ids {
+		if !f(slotID, topics[i]) {
+			break
+		}
+	}
+	return nil
+}
+
+// DirExtStore is an ExtStore that keeps each externalized payload in
+// its own file beneath a directory. Only the highest few slots are
+// also kept in memory.
+//
+// Values are stored with encoding/gob, so the concrete type(s) used
+// for Value must be registered with gob.Register.
+type DirExtStore struct {
+	dir string
+
+	mu    sync.Mutex
+	ids   []SlotID // sorted; all slots on disk
+	cache *MemExtStore
+}
+
+// NewDirExtStore produces a DirExtStore storing its files in dir,
+// creating the directory if necessary. The highest resident slots are
+// kept in memory as well as on disk.
+func NewDirExtStore(dir string, resident int) (*DirExtStore, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if resident < 1 {
+		resident = 1
+	}
+	s := &DirExtStore{
+		dir:   dir,
+		cache: NewMemExtStore(resident),
+	}
+	for _, info := range infos {
+		name := info.Name()
+		if filepath.Ext(name) != extFileSuffix {
+			continue
+		}
+		slotID, err := strconv.Atoi(strings.TrimSuffix(name, extFileSuffix))
+		if err != nil {
+			return nil, fmt.Errorf("parsing slot ID in %s: %w", name, err)
+		}
+		s.ids = insertSlotID(s.ids, SlotID(slotID))
+	}
+	return s, nil
+}
+
+// Get implements ExtStore.Get.
+func (s *DirExtStore) Get(slotID SlotID) (*ExtTopic, error) {
+	topic, _ := s.cache.Get(slotID)
+	if topic != nil {
+		return topic, nil
+	}
+
+	s.mu.Lock()
+	index := sort.Search(len(s.ids), func(i int) bool { return s.ids[i] >= slotID })
+	found := index < len(s.ids) && s.ids[index] == slotID
+	s.mu.Unlock()
+	if !found {
+		return nil, nil
+	}
+
+	topic = new(ExtTopic)
+	err := readGobFile(s.dir, extFilename(slotID), topic)
+	if err != nil {
+		return nil, err
+	}
+	return topic, nil
+}
+
+// Put implements ExtStore.Put.
+func (s *DirExtStore) Put(slotID SlotID, topic *ExtTopic) error {
+	err := writeGobFile(s.dir, extFilename(slotID), topic)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.ids = insertSlotID(s.ids, slotID)
+	s.mu.Unlock()
+
+	return s.cache.Put(slotID, topic)
+}
+
+// Highest implements ExtStore.Highest.
+func (s *DirExtStore) Highest() SlotID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.ids) == 0 {
+		return 0
+	}
+	return s.ids[len(s.ids)-1]
+}
+
+// Range implements ExtStore.Range.
+func (s *DirExtStore) Range(from, to SlotID, f func(SlotID, *ExtTopic) bool) error {
+	s.mu.Lock()
+	ids := slotIDRange(s.ids, from, to)
+	s.mu.Unlock()
+
+	for _, slotID := range ids {
+		topic, err := s.Get(slotID)
+		if err != nil {
+			return err
+		}
+		if !f(slotID, topic) {
+			break
+		}
+	}
+	return nil
+}
+
+func extFilename(slotID SlotID) string {
+	return strconv.Itoa(int(slotID)) + extFileSuffix
+}
+
+// Adds slotID to the sorted slice ids, if it's not already present.
+func insertSlotID(ids []SlotID, slotID SlotID) []SlotID {
+	index := sort.Search(len(ids), func(i int) bool { return ids[i] >= slotID })
+	if index < len(ids) && ids[index] == slotID {
+		return ids
+	}
+	ids = append(ids, 0)
+	copy(ids[index+1:], ids[index:])
+	ids[index] = slotID
+	return ids
+}
+
+// Returns a copy of the members of the sorted slice ids in the range [from, to].
+func slotIDRange(ids []SlotID, from, to SlotID) []SlotID {
+	start := sort.Search(len(ids), func(i int) bool { return ids[i] >= from })
+	end := sort.Search(len(ids), func(i int) bool { return ids[i] > to })
+	if start >= end {
+		return nil
+	}
+	return append([]SlotID(nil), ids[start:end]...)
+}
@@ -0,0 +1,128 @@
+package scp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestMemExtStore(t *testing.T) {
+	cases := []struct {
+		retain   int
+		put      []SlotID
+		from, to SlotID
+		want     []SlotID
+		highest  SlotID
+	}{
+		{
+			retain:  0,
+			put:     []SlotID{1, 2, 3, 4, 5},
+			from:    2,
+			to:      4,
+			want:    []SlotID{2, 3, 4},
+			highest: 5,
+		},
+		{
+			retain:  2,
+			put:     []SlotID{1, 2, 3, 4, 5},
+			from:    1,
+			to:      5,
+			want:    []SlotID{4, 5},
+			highest: 5,
+		},
+		{
+			retain:  3,
+			put:     []SlotID{5, 1, 3, 2},
+			from:    0,
+			to:      10,
+			want:    []SlotID{2, 3, 5},
+			highest: 5,
+		},
+		{
+			retain:  0,
+			put:     nil,
+			from:    0,
+			to:      10,
+			want:    nil,
+			highest: 0,
+		},
+	}
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%02d", i+1), func(t *testing.T) {
+			s := NewMemExtStore(tc.retain)
+			for _, slotID := range tc.put {
+				err := s.Put(slotID, &ExtTopic{C: Ballot{1, valtype(slotID)}, HN: 1})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := s.Highest(); got != tc.highest {
+				t.Errorf("got highest %d, want %d", got, tc.highest)
+			}
+			var got []SlotID
+			err := s.Range(tc.from, tc.to, func(slotID SlotID, topic *ExtTopic) bool {
+				if !ValueEqual(topic.C.X, valtype(slotID)) {
+					t.Errorf("slot %d: got value %s", slotID, topic.C.X)
+				}
+				got = append(got, slotID)
+				return true
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDirExtStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scp-ext")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewDirExtStore(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for slotID := SlotID(1); slotID <= 5; slotID++ {
+		err = s.Put(slotID, &ExtTopic{C: Ballot{1, valtype(10 * slotID)}, HN: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Reopen the store; everything should still be there,
+	// including slots no longer resident in memory.
+	s, err = NewDirExtStore(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Highest(); got != 5 {
+		t.Errorf("got highest %d, want 5", got)
+	}
+	for slotID := SlotID(1); slotID <= 5; slotID++ {
+		topic, err := s.Get(slotID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if topic == nil {
+			t.Fatalf("slot %d missing", slotID)
+		}
+		if !ValueEqual(topic.C.X, valtype(10*slotID)) {
+			t.Errorf("slot %d: got %s, want %d", slotID, topic.C.X, 10*slotID)
+		}
+	}
+	topic, err := s.Get(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topic != nil {
+		t.Errorf("got %s for slot 6, want nothing", topic)
+	}
+}
@@ -0,0 +1,47 @@
+package scp
+
+import "fmt"
+
+// ConsensusError is evidence that consensus has failed: a peer
+// externalized a value for slot I different from the one this node
+// externalized. Either the network lacks quorum intersection or the
+// peer (or this node) is faulty.
+type ConsensusError struct {
+	I SlotID
+
+	// Local is this node's EXTERNALIZE message for the slot.
+	Local *Msg
+
+	// Remote is the conflicting message from the peer.
+	Remote *Msg
+}
+
+func (e *ConsensusError) Error() string {
+	return fmt.Sprintf("consensus failure in slot %d: %s disagrees with %s", e.I, e.Remote, e.Local)
+}
+
+// FaultHandler is called when a node detects a fault that it cannot
+// recover from by itself, such as a *ConsensusError. The node keeps
+// running; the handler decides whether to halt it or the
+// application.
+//
+// A FaultHandler is called on the goroutine processing the node's
+// events (see Node.Run and Node.Step), so it must not call back into
+// the node.
+type FaultHandler func(error)
+
+// WithFaultHandler makes a node report faults to f. By default, a
+// node only logs them (at LevelError; see WithLogger).
+func WithFaultHandler(f FaultHandler) NodeOption {
+	return func(n *Node) {
+		n.faultHandler = f
+	}
+}
+
+// Reports a fault to n's logger and its FaultHandler, if any.
+func (n *Node) fault(err error) {
+	n.log(LevelError, "fault", Field{KeyErr, err})
+	if n.faultHandler != nil {
+		n.faultHandler(err)
+	}
+}
@@ -0,0 +1,72 @@
+package scp
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestConsensusError(t *testing.T) {
+	ext := NewMemExtStore(0)
+	err := ext.Put(1, &ExtTopic{C: Ballot{1, valtype(17)}, HN: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var faults []error
+	q := slicesToQSet([]NodeIDSet{toNodeIDSet("y")})
+	ch := make(chan *Msg, 10)
+	n := NewNode("x", q, ch, WithExtStore(ext), WithFaultHandler(func(err error) {
+		faults = append(faults, err)
+	}))
+
+	// A peer that agrees causes no fault.
+	n.Handle(NewMsg("y", 1, q, &ExtTopic{C: Ballot{1, valtype(17)}, HN: 1}))
+	for n.Step() {
+	}
+	if len(faults) > 0 {
+		t.Fatalf("got faults %v", faults)
+	}
+
+	// Nor does a malformed message that disagrees.
+	badQ := QSet{T: 2, M: []QSetMember{{N: nodeIDPtr("x")}}}
+	n.Handle(NewMsg("y", 1, badQ, &ExtTopic{C: Ballot{2, valtype(18)}, HN: 2}))
+	for n.Step() {
+	}
+	if len(faults) > 0 {
+		t.Fatalf("got faults %v for an invalid message", faults)
+	}
+
+	remote := NewMsg("y", 1, q, &ExtTopic{C: Ballot{2, valtype(18)}, HN: 2})
+	n.Handle(remote)
+	for n.Step() {
+	}
+	if len(faults) != 1 {
+		t.Fatalf("got %d faults, want 1", len(faults))
+	}
+	var cerr *ConsensusError
+	if !errors.As(faults[0], &cerr) {
+		t.Fatalf("got %v, want a *ConsensusError", faults[0])
+	}
+	if cerr.I != 1 {
+		t.Errorf("got slot %d, want 1", cerr.I)
+	}
+	if cerr.Remote != remote {
+		t.Errorf("got remote message %s, want %s", cerr.Remote, remote)
+	}
+	if topic, ok := cerr.Local.T.(*ExtTopic); !ok || topic.C.X != valtype(17) || cerr.Local.V != "x" {
+		t.Errorf("got local message %s", cerr.Local)
+	}
+
+	// The node keeps working.
+	n.Handle(NewMsg("y", 1, q, &NomTopic{X: ValueSet{valtype(19)}}))
+	for n.Step() {
+	}
+	select {
+	case msg := <-ch:
+		if _, ok := msg.T.(*ExtTopic); !ok {
+			t.Errorf("got %s, want an EXTERNALIZE message", msg)
+		}
+	default:
+		t.Error("node sent nothing")
+	}
+}
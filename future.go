@@ -0,0 +1,73 @@
+package scp
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrFutureSlot occurs when a message arrives for a slot too far
+	// beyond the node's highest externalized slot. See
+	// NodeConfig.FutureSlotWindow.
+	ErrFutureSlot = errors.New("slot is beyond the future-slot window")
+
+	// ErrFutureFull occurs when a message for a future slot arrives
+	// and the buffer for such messages is full. See
+	// NodeConfig.MaxFutureMsgs.
+	ErrFutureFull = errors.New("future-slot buffer is full")
+)
+
+// Holds msg, for a slot whose predecessor has not yet externalized,
+// until the predecessor does (see replayFuture).
+func (n *Node) bufferFuture(msg *Msg) error {
+	highest := n.ext.Highest()
+	if msg.I <= highest {
+		// The previous slot externalized long ago and is no longer
+		// retained in n.ext.
+		return fmt.Errorf("slot %d: %w", msg.I, ErrNoPrev)
+	}
+	if msg.I > highest+SlotID(n.cfg.FutureSlotWindow) {
+		return fmt.Errorf("slot %d (highest externalized is %d): %w", msg.I, highest, ErrFutureSlot)
+	}
+	m := n.future[msg.I]
+	if m == nil {
+		m = make(map[NodeID]*Msg)
+		n.future[msg.I] = m
+	}
+	if _, ok := m[msg.V]; !ok {
+		if n.numFuture >= n.cfg.MaxFutureMsgs {
+			return fmt.Errorf("slot %d: %w", msg.I, ErrFutureFull)
+		}
+		n.numFuture++
+	}
+	m[msg.V] = msg
+	return nil
+}
+
+// Queues the buffered messages for slot i, which may now be
+// processed, and discards any buffered messages for slots that can
+// now never be processed.
+func (n *Node) replayFuture(i SlotID) {
+	for slotID, m := range n.future {
+		if slotID < i {
+			n.numFuture -= len(m)
+			delete(n.future, slotID)
+		}
+	}
+
+	m := n.future[i]
+	if m == nil {
+		return
+	}
+	delete(n.future, i)
+	n.numFuture -= len(m)
+
+	// Replay messages in a deterministic order.
+	var senders NodeIDSet
+	for sender := range m {
+		senders = senders.Add(sender)
+	}
+	for _, sender := range senders {
+		n.cmds.writeHi(&msgCmd{msg: m[sender]})
+	}
+}
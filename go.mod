@@ -2,4 +2,7 @@ module github.com/bobg/scp
 
 go 1.14
 
-require github.com/davecgh/go-xdr v0.0.0-20161123171359-e6a2ba005892
+require (
+	github.com/BurntSushi/toml v1.6.0
+	github.com/davecgh/go-xdr v0.0.0-20161123171359-e6a2ba005892
+)
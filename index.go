@@ -0,0 +1,363 @@
+package scp
+
+import "sort"
+
+// This file contains the machinery behind the quorum and blocking-set
+// searches in quorum.go. A slot performs many searches for each
+// message it handles, over the same set of peer messages, so it
+// keeps an indexed view of them (a qview): node IDs are interned to
+// small integers, quorum slices are compiled to use those, and the
+// set of nodes found so far is a bitset that is updated in place as
+// the search proceeds and backtracks.
+
+// A nodeIndex assigns each NodeID it sees a small integer, starting
+// at 0. Each slot has its own (in its qview), so the IDs a slot sees,
+// including any made up by a misbehaving peer, are forgotten along
+// with the slot.
+type nodeIndex struct {
+	ids []NodeID
+	idx map[NodeID]int
+}
+
+func newNodeIndex() *nodeIndex {
+	return &nodeIndex{idx: make(map[NodeID]int)}
+}
+
+// Returns the index for id, assigning one if necessary.
+func (x *nodeIndex) intern(id NodeID) int {
+	if i, ok := x.idx[id]; ok {
+		return i
+	}
+	i := len(x.ids)
+	x.ids = append(x.ids, id)
+	x.idx[id] = i
+	return i
+}
+
+// Produces the indexed form of q.
+func (x *nodeIndex) compile(q QSet) *iqset {
+	result := &iqset{t: q.T, m: make([]imember, 0, len(q.M))}
+	for _, m := range q.M {
+		switch {
+		case m.N != nil:
+			result.m = append(result.m, imember{n: x.intern(*m.N)})
+
+		case m.Q != nil:
+			result.m = append(result.m, imember{q: x.compile(*m.Q)})
+		}
+	}
+	return result
+}
+
+type (
+	// An iqset is a QSet whose node IDs have been replaced by
+	// their indexes in a nodeIndex.
+	iqset struct {
+		t int
+		m []imember
+	}
+
+	// An imember is a member of an iqset: a node index if q is nil,
+	// otherwise an inner iqset.
+	imember struct {
+		n int
+		q *iqset
+	}
+)
+
+// A bitset is a set of node indexes.
+type bitset []uint64
+
+func (b bitset) has(i int) bool {
+	w := i / 64
+	return w < len(b) && b[w]&(1<<uint(i%64)) != 0
+}
+
+func (b *bitset) add(i int) {
+	w := i / 64
+	for len(*b) <= w {
+		*b = append(*b, 0)
+	}
+	(*b)[w] |= 1 << uint(i%64)
+}
+
+func (b bitset) remove(i int) {
+	if w := i / 64; w < len(b) {
+		b[w] &^= 1 << uint(i%64)
+	}
+}
+
+// A qview is the indexed form of a node's quorum slices and its
+// peers' latest messages, against which quorums and blocking sets
+// can be found.
+//
+// The nodes found so far during a search are kept in sofar, and
+// also, in the order they were added, in trail. Backtracking removes
+// nodes from the end of trail. Results of predicate tests are
+// memoized per (message, predicate) until clearMemo is called, so a
+// test must depend only on the predicate and the message. A slot
+// clears the memo whenever a message changes and at the start of each
+// command it processes (see Slot.advance), so the memo spans the
+// searches for one handle call. Predicates with keys (see
+// keyedPredicate) are memoized by key, so the separate searches for
+// the same statement share results; others only by identity.
+// (Narrowing predicates return themselves when a test doesn't narrow
+// them, so the same one is seen again and again as a search
+// proceeds.)
+type qview struct {
+	x     *nodeIndex
+	self  int
+	q     *iqset
+	msgs  []*Msg   // by node index; nil where there is none
+	qsets []*iqset // compiled msgs[i].Q, filled in as needed
+
+	sofar bitset
+	trail []int
+	memo  map[memoKey]memoEntry
+}
+
+type memoKey struct {
+	pred    predicate // nil if key is set
+	key     predKey
+	node    int
+	confirm bool // whether this is the result of confirmed rather than test
+}
+
+type memoEntry struct {
+	pred, res predicate
+}
+
+func newQView(x *nodeIndex, self NodeID, q QSet, msgs map[NodeID]*Msg) *qview {
+	v := &qview{
+		x:    x,
+		self: x.intern(self),
+		q:    x.compile(q),
+		memo: make(map[memoKey]memoEntry),
+	}
+	for _, msg := range msgs {
+		v.setMsg(msg)
+	}
+	return v
+}
+
+// Records msg as the latest message from its sender.
+func (v *qview) setMsg(msg *Msg) {
+	i := v.x.intern(msg.V)
+	for len(v.msgs) <= i {
+		v.msgs = append(v.msgs, nil)
+		v.qsets = append(v.qsets, nil)
+	}
+	v.msgs[i] = msg
+	v.qsets[i] = nil
+	v.clearMemo()
+}
+
+// Forgets memoized predicate results.
+func (v *qview) clearMemo() {
+	for k := range v.memo {
+		delete(v.memo, k)
+	}
+}
+
+func (v *qview) msg(i int) *Msg {
+	if i < len(v.msgs) {
+		return v.msgs[i]
+	}
+	return nil
+}
+
+func (v *qview) qset(i int) *iqset {
+	if v.qsets[i] == nil {
+		v.qsets[i] = v.x.compile(v.msgs[i].Q)
+	}
+	return v.qsets[i]
+}
+
+// Finds a quorum in which every node satisfies the given
+// predicate. The view's node itself is presumed to satisfy the
+// predicate.
+//
+// If pred is a confirmPredicate, the result may omit the
+// quorum members behind a node that has confirmed the statement
+// being tested. It is non-empty exactly when a quorum exists.
+func (v *qview) findQuorum(pred predicate) NodeIDSet {
+	pred = v.start(pred)
+	v.add(v.self)
+	ok, pred := v.findQuorumHelper(v.q.t, v.q.m, pred)
+	if !ok {
+		return nil
+	}
+	finish(pred)
+	return v.result()
+}
+
+func (v *qview) findQuorumHelper(threshold int, members []imember, pred predicate) (bool, predicate) {
+	if threshold == 0 {
+		return true, pred
+	}
+	if threshold > len(members) {
+		return false, pred
+	}
+	mark := len(v.trail)
+	m0 := members[0]
+	if m0.q == nil {
+		if v.sofar.has(m0.n) {
+			return v.findQuorumHelper(threshold-1, members[1:], pred)
+		}
+		if cp, ok := pred.(confirmPredicate); ok {
+			if nextPred := v.confirmed(cp, m0.n); nextPred != nil {
+				// This node has confirmed the statement, so it already
+				// has a satisfying quorum. No need to search its slices.
+				v.add(m0.n)
+				if ok, pred2 := v.findQuorumHelper(threshold-1, members[1:], nextPred); ok {
+					return true, pred2
+				}
+				v.undo(mark)
+			}
+		}
+		if nextPred := v.test(pred, m0.n); nextPred != nil {
+			v.add(m0.n)
+			q := v.qset(m0.n)
+			if ok, pred2 := v.findQuorumHelper(q.t, q.m, nextPred); ok {
+				return v.findQuorumHelper(threshold-1, members[1:], pred2)
+			}
+			v.undo(mark)
+		}
+	} else {
+		if ok, pred2 := v.findQuorumHelper(m0.q.t, m0.q.m, pred); ok {
+			return v.findQuorumHelper(threshold-1, members[1:], pred2)
+		}
+		v.undo(mark)
+	}
+	return v.findQuorumHelper(threshold, members[1:], pred)
+}
+
+// Checks that at least one node in each quorum slice satisfies pred
+// (excluding the view's node).
+//
+// Works by finding len(q.M)-q.T+1 members for which pred is true.
+func (v *qview) findBlockingSet(pred predicate) NodeIDSet {
+	pred = v.start(pred)
+	ok, pred := v.findBlockingSetHelper(len(v.q.m)-v.q.t+1, v.q.m, pred)
+	if !ok {
+		return nil
+	}
+	finish(pred)
+	return v.result()
+}
+
+func (v *qview) findBlockingSetHelper(needed int, members []imember, pred predicate) (bool, predicate) {
+	if needed == 0 {
+		return true, pred
+	}
+	if needed > len(members) {
+		return false, pred
+	}
+	m0 := members[0]
+	if m0.q == nil {
+		if nextPred := v.test(pred, m0.n); nextPred != nil {
+			v.add(m0.n)
+			return v.findBlockingSetHelper(needed-1, members[1:], nextPred)
+		}
+	} else {
+		mark := len(v.trail)
+		if ok, pred2 := v.findBlockingSetHelper(len(m0.q.m)-m0.q.t+1, m0.q.m, pred); ok {
+			return v.findBlockingSetHelper(needed-1, members[1:], pred2)
+		}
+		v.undo(mark)
+	}
+	return v.findBlockingSetHelper(needed, members[1:], pred)
+}
+
+// Prepares v for a new search with pred, returning the predicate to
+// search with.
+func (v *qview) start(pred predicate) predicate {
+	v.undo(0)
+	if f, ok := pred.(fpred); ok {
+		// Functions can't be map keys.
+		return &boolPred{f: f}
+	}
+	return pred
+}
+
+func (v *qview) add(i int) {
+	if !v.sofar.has(i) {
+		v.sofar.add(i)
+		v.trail = append(v.trail, i)
+	}
+}
+
+// Removes the nodes added since the trail had length mark.
+func (v *qview) undo(mark int) {
+	for _, i := range v.trail[mark:] {
+		v.sofar.remove(i)
+	}
+	v.trail = v.trail[:mark]
+}
+
+// Produces the nodes found, as a NodeIDSet.
+func (v *qview) result() NodeIDSet {
+	result := make(NodeIDSet, 0, len(v.trail))
+	for _, i := range v.trail {
+		result = append(result, v.x.ids[i])
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
+// Memoized pred.test on node i's message.
+func (v *qview) test(pred predicate, i int) predicate {
+	msg := v.msg(i)
+	if msg == nil {
+		return nil
+	}
+	k := v.memoKey(pred, i, false)
+	if res, ok := v.lookup(k, pred); ok {
+		return res
+	}
+	res := pred.test(msg)
+	v.memo[k] = memoEntry{pred: pred, res: res}
+	return res
+}
+
+// Memoized pred.confirmed on node i's message.
+func (v *qview) confirmed(pred confirmPredicate, i int) predicate {
+	msg := v.msg(i)
+	if msg == nil {
+		return nil
+	}
+	k := v.memoKey(pred, i, true)
+	if res, ok := v.lookup(k, pred); ok {
+		return res
+	}
+	res := pred.confirmed(msg)
+	v.memo[k] = memoEntry{pred: pred, res: res}
+	return res
+}
+
+func (v *qview) memoKey(pred predicate, i int, confirm bool) memoKey {
+	if kp, ok := pred.(keyedPredicate); ok {
+		if key, ok := kp.key(); ok {
+			return memoKey{key: key, node: i, confirm: confirm}
+		}
+	}
+	return memoKey{pred: pred, node: i, confirm: confirm}
+}
+
+// Finds the memoized result for pred under k. An entry made by a
+// different predicate with the same key can be used as long as the
+// test failed or left that predicate unchanged; a narrowed copy of
+// it, though, would finish (see finalPredicate) into the other
+// predicate's results, so in that case there's no usable entry.
+func (v *qview) lookup(k memoKey, pred predicate) (predicate, bool) {
+	e, ok := v.memo[k]
+	switch {
+	case !ok:
+		return nil, false
+	case e.pred == pred || e.res == nil:
+		return e.res, true
+	case e.res == e.pred:
+		return pred, true
+	}
+	return nil, false
+}
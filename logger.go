@@ -0,0 +1,115 @@
+package scp
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Level is the severity of a log entry.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return fmt.Sprintf("Level(%d)", int(l))
+}
+
+// Field is a key-value pair attached to a log entry.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Keys of the fields that a node attaches to its log entries.
+const (
+	KeyNode   = "node"
+	KeySlot   = "slot"
+	KeyPhase  = "phase"
+	KeySender = "sender"
+	KeyMsg    = "msg"
+	KeyResp   = "resp"
+	KeyErr    = "err"
+)
+
+// Logger receives a node's log entries. The node attaches its own
+// ID to every entry (as the field KeyNode), and entries about a slot
+// carry the slot ID and phase too.
+//
+// Log is called on the goroutine processing the node's events, so it
+// should not block for long.
+type Logger interface {
+	Log(level Level, msg string, fields ...Field)
+}
+
+// NopLogger is a Logger that discards everything. It is the default
+// for nodes not given WithLogger.
+type NopLogger struct{}
+
+// Log implements Logger.
+func (NopLogger) Log(Level, string, ...Field) {}
+
+// StdLogger is a Logger that writes entries at or above level Min to
+// L (or, if L is nil, to the standard log package's default logger),
+// one per line, followed by their fields in key=value form.
+type StdLogger struct {
+	L   *log.Logger
+	Min Level
+}
+
+// Log implements Logger.
+func (l StdLogger) Log(level Level, msg string, fields ...Field) {
+	if level < l.Min {
+		return
+	}
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "%s %s", level, msg)
+	for _, f := range fields {
+		val := fmt.Sprint(f.Value)
+		if val == "" || strings.ContainsAny(val, " \t\n\"=") {
+			val = fmt.Sprintf("%q", val)
+		}
+		fmt.Fprintf(buf, " %s=%s", f.Key, val)
+	}
+	if l.L != nil {
+		l.L.Print(buf.String())
+	} else {
+		log.Print(buf.String())
+	}
+}
+
+// WithLogger makes a node send its log entries to l.
+func WithLogger(l Logger) NodeOption {
+	return func(n *Node) {
+		n.logger = l
+	}
+}
+
+// Sends a log entry to n's logger, tagged with n's ID.
+func (n *Node) log(level Level, msg string, fields ...Field) {
+	fields = append([]Field{{KeyNode, n.ID}}, fields...)
+	n.logger.Log(level, msg, fields...)
+}
+
+// Sends a log entry to the node's logger, tagged with the slot's ID
+// and phase.
+func (s *Slot) log(level Level, msg string, fields ...Field) {
+	fields = append([]Field{{KeySlot, s.ID}, {KeyPhase, s.Ph}}, fields...)
+	s.V.log(level, msg, fields...)
+}
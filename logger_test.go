@@ -0,0 +1,101 @@
+package scp
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"testing"
+)
+
+type logEntry struct {
+	level  Level
+	msg    string
+	fields map[string]interface{}
+}
+
+type testLogger struct {
+	entries []logEntry
+}
+
+func (l *testLogger) Log(level Level, msg string, fields ...Field) {
+	e := logEntry{level: level, msg: msg, fields: make(map[string]interface{})}
+	for _, f := range fields {
+		e.fields[f.Key] = f.Value
+	}
+	l.entries = append(l.entries, e)
+}
+
+func TestLoggerFields(t *testing.T) {
+	ch := make(chan *Msg, 1)
+	logger := new(testLogger)
+	n := NewNode("x", slicesToQSet([]NodeIDSet{toNodeIDSet("y")}), ch, WithLogger(logger))
+
+	n.Handle(NewMsg("y", 1, slicesToQSet([]NodeIDSet{toNodeIDSet("x")}), &NomTopic{X: ValueSet{valtype(1)}}))
+	for n.Step() {
+	}
+
+	var found bool
+	for _, e := range logger.entries {
+		if e.msg != "handled message" {
+			continue
+		}
+		found = true
+		if e.level != LevelDebug {
+			t.Errorf("got level %s, want %s", e.level, LevelDebug)
+		}
+		want := map[string]interface{}{
+			KeyNode:   NodeID("x"),
+			KeySlot:   SlotID(1),
+			KeyPhase:  PhNom,
+			KeySender: NodeID("y"),
+		}
+		for k, v := range want {
+			if e.fields[k] != v {
+				t.Errorf("got %s=%v, want %v", k, e.fields[k], v)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("no \"handled message\" entry in %v", logger.entries)
+	}
+}
+
+func TestStdLogger(t *testing.T) {
+	cases := []struct {
+		min    Level
+		level  Level
+		msg    string
+		fields []Field
+		want   string
+	}{
+		{
+			min:   LevelInfo,
+			level: LevelDebug,
+			msg:   "hidden",
+		},
+		{
+			min:    LevelInfo,
+			level:  LevelWarn,
+			msg:    "something happened",
+			fields: []Field{{KeyNode, NodeID("x")}, {KeySlot, SlotID(3)}, {KeyPhase, PhCommit}},
+			want:   "WARN something happened node=x slot=3 phase=COMMIT\n",
+		},
+		{
+			min:    LevelDebug,
+			level:  LevelError,
+			msg:    "oops",
+			fields: []Field{{KeyErr, fmt.Errorf("no good")}, {"empty", ""}},
+			want:   "ERROR oops err=\"no good\" empty=\"\"\n",
+		},
+	}
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%02d", i+1), func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			l := StdLogger{L: log.New(buf, "", 0), Min: tc.min}
+			l.Log(tc.level, tc.msg, tc.fields...)
+			if got := buf.String(); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -14,6 +14,7 @@ type Msg struct {
 	I SlotID // ID of the slot that this message is about.
 	Q QSet   // Quorum slices of the sending node.
 	T Topic  // The payload: a *NomTopic, a *NomPrepTopic, *PrepTopic, *CommitTopic, or *ExtTopic.
+	S []byte // Signature by V over the rest of the message (see Signer), or nil.
 }
 
 var msgCounter int32
@@ -61,6 +62,11 @@ func (e *Msg) valid() (err error) {
 		return nil
 	}
 
+	err = e.Q.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid Q: %w", err)
+	}
+
 	switch topic := e.T.(type) {
 	case *NomTopic:
 		err := nom(topic)
@@ -182,6 +188,29 @@ func (e *Msg) acceptsPreparedSet() BallotSet {
 	return result
 }
 
+// Tells whether e accepts b as prepared. Accepting a ballot as
+// prepared means accepting lower ballots with the same value as
+// prepared too.
+func (e *Msg) acceptsPrepared(b Ballot) bool {
+	return preparedBy(b, e.acceptsPreparedSet())
+}
+
+// Tells whether e votes or accepts b as prepared (see
+// acceptsPrepared).
+func (e *Msg) votesOrAcceptsPrepared(b Ballot) bool {
+	return preparedBy(b, e.votesOrAcceptsPreparedSet())
+}
+
+// Tells whether bs contains b or a higher ballot with the same value.
+func preparedBy(b Ballot, bs BallotSet) bool {
+	for _, other := range bs {
+		if other.N >= b.N && ValueEqual(other.X, b.X) {
+			return true
+		}
+	}
+	return false
+}
+
 // Tells whether e votes commit(b) or accepts commit(b) for any ballot
 // b whose value is v and whose counter is in the range [min,max]
 // (inclusive). If so, returns the new min/max that is the overlap
@@ -268,6 +297,48 @@ func (e *Msg) acceptsCommit(v Value, min, max int) (bool, int, int) {
 	return false, 0, 0
 }
 
+// Returns the highest ballot that e confirms as prepared, or the zero
+// ballot if there is none. (Lower ballots with the same value are
+// confirmed prepared too.)
+func (e *Msg) confirmsPrepared() Ballot {
+	switch topic := e.T.(type) {
+	case *NomPrepTopic:
+		if topic.HN > 0 {
+			return Ballot{N: topic.HN, X: topic.B.X}
+		}
+
+	case *PrepTopic:
+		if topic.HN > 0 {
+			return Ballot{N: topic.HN, X: topic.B.X}
+		}
+
+	case *ExtTopic:
+		return Ballot{N: math.MaxInt32, X: topic.C.X}
+	}
+	return ZeroBallot
+}
+
+// Tells whether e confirms commit(b) for any ballot b whose value is v
+// and whose counter is in the range [min,max] (inclusive). If so,
+// returns the new min/max that is the overlap between the input and
+// what e confirms.
+func (e *Msg) confirmsCommit(v Value, min, max int) (bool, int, int) {
+	topic, ok := e.T.(*ExtTopic)
+	if !ok || !ValueEqual(topic.C.X, v) {
+		return false, 0, 0
+	}
+	if topic.C.N > max || topic.HN < min {
+		return false, 0, 0
+	}
+	if topic.C.N > min {
+		min = topic.C.N
+	}
+	if topic.HN < max {
+		max = topic.HN
+	}
+	return true, min, max
+}
+
 // String produces a readable representation of a message.
 func (e *Msg) String() string {
 	return fmt.Sprintf("(C=%d V=%s I=%d: %s)", e.C, e.V, e.I, e.T)
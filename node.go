@@ -7,9 +7,9 @@ import (
 	"crypto/sha256"
 	"errors"
 	"fmt"
-	"log"
 	"math/big"
 	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/davecgh/go-xdr/xdr"
@@ -30,133 +30,384 @@ type Node struct {
 	Q QSet
 
 	// FP/FQ is a rational giving the odds that a call to Handle will fail (drop the incoming message).
-	// Self messages (from the node to itself) are never dropped.
 	// FQ==0 is treated as 0/1.
 	FP, FQ int
 
-	// mu sync.Mutex
+	// mu guards the node's mutable state: pending, future, and the
+	// slots themselves. It is held while processing each command
+	// (see do).
+	mu sync.Mutex
 
 	// pending holds Slot objects during nomination and balloting.
 	pending map[SlotID]*Slot
 
 	// ext holds externalized values for slots that have completed
 	// balloting.
-	ext map[SlotID]*ExtTopic
-
-	cmds *cmdChan
+	ext ExtStore
+
+	// extCh is closed (and replaced) whenever a slot externalizes (see
+	// WaitExternalized).
+	extMu sync.Mutex
+	extCh chan struct{}
+
+	// future holds messages for slots that can't be created yet
+	// because their previous slots haven't externalized (see
+	// bufferFuture).
+	future    map[SlotID]map[NodeID]*Msg
+	numFuture int
+
+	cfg       NodeConfig
+	clock     Clock
+	persister Persister
+	signer    Signer
+	verifier  Verifier
+	obs       Observer
+	logger    Logger
+
+	faultHandler FaultHandler
+
+	// delayEnd is when the current simulated delay, if any, ends, and
+	// delayTimer goes off then (see Delay).
+	delayEnd   time.Time
+	delayTimer Timer
+
+	cmds *cmdQueue
 	send chan<- *Msg
+
+	// outbox holds messages to send on send once mu is released.
+	outbox []*Msg
+
+	// See rebroadcast.
+	rebroadcastTimer    Timer
+	rebroadcastInterval time.Duration
+	progressed          bool // whether the node has emitted a message since the last rebroadcast
 }
 
-// NewNode produces a new node.
-func NewNode(id NodeID, q QSet, ch chan<- *Msg, ext map[SlotID]*ExtTopic) *Node {
-	if ext == nil {
-		ext = make(map[SlotID]*ExtTopic)
+// NodeOption is the type of an optional argument to NewNode.
+type NodeOption func(*Node)
+
+// WithClock makes a node use the given Clock instead of RealClock.
+func WithClock(c Clock) NodeOption {
+	return func(n *Node) {
+		n.clock = c
 	}
-	return &Node{
+}
+
+// WithPersister makes a node record its state in p as it runs. See
+// RestoreNode.
+func WithPersister(p Persister) NodeOption {
+	return func(n *Node) {
+		n.persister = p
+	}
+}
+
+// WithExtStore makes a node keep its externalized values in es. By
+// default a node uses an unbounded MemExtStore.
+//
+// A node can process slot i only once it knows the value externalized
+// for slot i-1 (see Node.G), so a node joining a network at slot i
+// should be given an ExtStore that already contains slot i-1.
+func WithExtStore(es ExtStore) NodeOption {
+	return func(n *Node) {
+		n.ext = es
+	}
+}
+
+// NewNode produces a new node.
+func NewNode(id NodeID, q QSet, ch chan<- *Msg, opts ...NodeOption) *Node {
+	n := &Node{
 		ID:      id,
 		Q:       q,
 		pending: make(map[SlotID]*Slot),
-		ext:     ext,
-		cmds:    newCmdChan(),
+		ext:     NewMemExtStore(0),
+		future:  make(map[SlotID]map[NodeID]*Msg),
+		cfg:     DefaultNodeConfig,
+		clock:   RealClock,
+		obs:     NopObserver{},
+		logger:  NopLogger{},
 		send:    ch,
 	}
+	for _, opt := range opts {
+		opt(n)
+	}
+	n.cmds = newCmdQueue(n.cfg.QueueCapacity, n.cfg.Overflow)
+	return n
+}
+
+// Config returns the node's parameters.
+func (n *Node) Config() NodeConfig {
+	return n.cfg
 }
 
 // Run processes incoming events for the node. It returns only when
 // its context is canceled and should be launched as a goroutine.
+//
+// Before returning, Run stops the timers of all pending slots, so
+// nothing more is queued for the node. The slots' state remains
+// available (e.g. via Status), but the node should not be run again.
 func (n *Node) Run(ctx context.Context) {
-	var delayUntil *time.Time
+	defer n.cmds.stop()
+	defer n.close()
+
 	for {
 		cmd, ok := n.cmds.read(ctx)
 		if !ok {
 			if ctx.Err() != nil {
-				n.Logf("context canceled, Run exiting")
+				n.log(LevelInfo, "context canceled, Run exiting")
 			} else {
-				n.Logf("unknown command-reading error, Run exiting")
+				n.log(LevelError, "unknown command-reading error, Run exiting")
 			}
 			return
 		}
-		switch cmd := cmd.(type) {
-		case *msgCmd:
-			func() {
-				if delayUntil != nil {
-					dur := time.Until(*delayUntil)
-					if dur > 0 {
-						time.Sleep(dur)
-					}
-					delayUntil = nil
-				}
-				err := n.handle(cmd.msg)
-				if err != nil {
-					n.Logf("ERROR %s", err)
-				}
-			}()
+		n.do(cmd)
+	}
+}
+
+// Stops the node's timers, including those of all pending slots.
+func (n *Node) close() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.cancelRebroadcast()
+	if n.delayTimer != nil {
+		n.delayTimer.Stop()
+		n.delayTimer = nil
+	}
+	for _, s := range n.pending {
+		s.close()
+	}
+}
+
+// Tells whether s is still one of n's pending slots. Commands queued
+// for a slot that has since externalized (or been replaced) are
+// ignored.
+func (n *Node) isLive(s *Slot) bool {
+	if n.pending[s.ID] == s {
+		return true
+	}
+	n.log(LevelDebug, "ignoring command for closed slot", Field{KeySlot, s.ID})
+	return false
+}
+
+// Step processes the next queued event for the node, if there is
+// one, without waiting. It reports whether an event was
+// processed. It is an alternative to Run for callers (such as
+// simulators) that need to control exactly when a node does its
+// work. Step must not be called concurrently with Run or with itself.
+func (n *Node) Step() bool {
+	cmd, ok := n.cmds.tryRead()
+	if !ok {
+		return false
+	}
+	n.do(cmd)
+	return true
+}
+
+func (n *Node) do(cmd Cmd) {
+	n.mu.Lock()
+	n.doLocked(cmd)
+	n.mu.Unlock()
+
+	n.flush()
+}
+
+// Sends the messages queued by transmit. This happens outside the
+// lock, so that a slow reader of n.send doesn't hold up queries like
+// Status.
+func (n *Node) flush() {
+	n.mu.Lock()
+	outbox := n.outbox
+	n.outbox = nil
+	n.mu.Unlock()
+
+	for _, msg := range outbox {
+		n.send <- msg
+	}
+}
+
+func (n *Node) doLocked(cmd Cmd) {
+	switch cmd := cmd.(type) {
+	case *msgCmd:
+		func() {
+			err := n.verify(cmd.msg)
+			if err != nil {
+				n.log(LevelWarn, "discarding message", Field{KeySlot, cmd.msg.I}, Field{KeySender, cmd.msg.V}, Field{KeyErr, err})
+				return
+			}
+			err = n.handle(cmd.msg)
+			if err != nil {
+				n.log(LevelError, "handling message", Field{KeySlot, cmd.msg.I}, Field{KeySender, cmd.msg.V}, Field{KeyErr, err})
+			}
+		}()
+
+	case *nominateCmd:
+		eligible, err := n.nominate(cmd.i, cmd.vals)
+		cmd.reply <- nominateResult{eligible: eligible, err: err}
+
+	case *delayCmd:
+		n.delay(time.Duration(cmd.ms) * time.Millisecond)
 
-		case *delayCmd:
-			delayUntil = new(time.Time)
-			*delayUntil = time.Now().Add(time.Duration(cmd.ms * int(time.Millisecond)))
+	case *endDelayCmd:
+		if !n.clock.Now().Before(n.delayEnd) {
+			n.delayTimer = nil
+			n.cmds.resume()
+		}
 
-		case *deferredUpdateCmd:
-			func() {
-				cmd.slot.deferredUpdate()
-			}()
+	case *deferredUpdateCmd:
+		if !n.isLive(cmd.slot) {
+			break
+		}
+		func() {
+			err := cmd.slot.deferredUpdate()
+			if err != nil {
+				cmd.slot.log(LevelError, "deferred update", Field{KeyErr, err})
+			}
+		}()
 
-		case *newRoundCmd:
-			func() {
-				err := cmd.slot.newRound()
+	case *raiseBNCmd:
+		if !n.isLive(cmd.slot) {
+			break
+		}
+		func() {
+			err := cmd.slot.raiseBN()
+			if err != nil {
+				cmd.slot.log(LevelError, "raising ballot counter", Field{KeyErr, err})
+			}
+		}()
+
+	case *newRoundCmd:
+		if !n.isLive(cmd.slot) {
+			break
+		}
+		func() {
+			err := cmd.slot.newRound()
+			if err != nil {
+				cmd.slot.log(LevelError, "new nomination round", Field{KeyErr, err})
+			}
+		}()
+
+	case *rebroadcastCmd:
+		err := n.rebroadcast()
+		if err != nil {
+			n.log(LevelError, "rebroadcasting", Field{KeyErr, err})
+		}
+
+	case *rehandleCmd:
+		if !n.isLive(cmd.slot) {
+			break
+		}
+		func() {
+			// Handle messages in a deterministic order.
+			var peerIDs NodeIDSet
+			for peerID := range cmd.slot.M {
+				peerIDs = peerIDs.Add(peerID)
+			}
+			for _, peerID := range peerIDs {
+				err := n.handle(cmd.slot.M[peerID])
 				if err != nil {
-					n.Logf("ERROR %s", err)
-				}
-			}()
-
-		case *rehandleCmd:
-			func() {
-				for _, msg := range cmd.slot.M {
-					err := n.handle(msg)
-					if err != nil {
-						n.Logf("ERROR %s", err)
-					}
+					cmd.slot.log(LevelError, "rehandling message", Field{KeySender, peerID}, Field{KeyErr, err})
 				}
-			}()
-		}
+			}
+		}()
+	}
+}
+
+// Holds off peer messages for duration d as measured by n's clock,
+// beyond the end of any delay already in effect. Rather than
+// blocking, this pauses the queue and sets a timer to resume it, so
+// internal commands and queries like Status proceed in the meantime,
+// and a node driven by Step with a FakeClock simply has nothing to do
+// until the clock advances.
+func (n *Node) delay(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	now := n.clock.Now()
+	if n.delayEnd.Before(now) {
+		n.delayEnd = now
+	}
+	n.delayEnd = n.delayEnd.Add(d)
+	if n.delayTimer != nil {
+		n.delayTimer.Stop()
 	}
+	n.cmds.pause()
+	n.delayTimer = n.clock.AfterFunc(n.delayEnd.Sub(now), func() {
+		n.cmds.writeHi(&endDelayCmd{})
+	})
 }
 
 func (n *Node) deferredUpdate(s *Slot) {
-	n.cmds.write(&deferredUpdateCmd{slot: s})
+	n.cmds.writeHi(&deferredUpdateCmd{slot: s})
+}
+
+func (n *Node) raiseBN(s *Slot) {
+	n.cmds.writeHi(&raiseBNCmd{slot: s})
 }
 
 func (n *Node) newRound(s *Slot) {
-	n.cmds.write(&newRoundCmd{slot: s})
+	n.cmds.writeHi(&newRoundCmd{slot: s})
 }
 
 func (n *Node) rehandle(s *Slot) {
-	n.cmds.write(&rehandleCmd{slot: s})
+	n.cmds.writeHi(&rehandleCmd{slot: s})
 }
 
-// Handle queues an incoming protocol message. When processed it will
-// send a protocol message in response on n.send unless the incoming
-// message is ignored. (A message is ignored if it's invalid,
-// redundant, or older than another message already received from the
-// same sender.)
-func (n *Node) Handle(msg *Msg) {
-	if msg.V != n.ID && n.FQ > 0 && n.FP < n.FQ {
+// Handle queues an incoming protocol message from a peer. When
+// processed it will send a protocol message in response on n.send
+// unless the incoming message is ignored. (A message is ignored if
+// it's invalid, redundant, or older than another message already
+// received from the same sender.)
+//
+// If the node's queue of peer messages is full, Handle waits for
+// room, drops the oldest queued message, or returns ErrQueueFull,
+// depending on the node's NodeConfig.Overflow. Once Run has
+// returned, Handle returns ErrStopped.
+//
+// Messages from n itself are rejected. To propose a value, use
+// Nominate.
+func (n *Node) Handle(msg *Msg) error {
+	if msg.V == n.ID {
+		return fmt.Errorf("message from self for slot %d (use Nominate)", msg.I)
+	}
+	if n.FQ > 0 && n.FP < n.FQ {
 		// decide whether to simulate dropping this message
 		if rand.Intn(n.FQ) < n.FP {
-			n.Logf("dropping message %s", msg)
-			return
+			n.log(LevelDebug, "dropping message", Field{KeySlot, msg.I}, Field{KeySender, msg.V}, Field{KeyMsg, msg})
+			return nil
 		}
 	}
-	n.cmds.write(&msgCmd{msg: msg})
+	return n.cmds.writeLo(&msgCmd{msg: msg})
 }
 
-// Delay simulates a network delay.
+// Delay simulates a network delay: the node handles no peer messages
+// for the next ms milliseconds as measured by its clock, or, if a
+// delay is already in effect, for ms milliseconds more. Delays are
+// internal commands, so unlike peer messages they are never dropped
+// or refused when the queue is full.
 func (n *Node) Delay(ms int) {
-	n.cmds.write(&delayCmd{ms: ms})
+	n.cmds.writeHi(&delayCmd{ms: ms})
+}
+
+// QueueStats reports on the node's queue of commands waiting to be
+// processed. It is safe for concurrent use.
+func (n *Node) QueueStats() QueueStats {
+	return n.cmds.stats()
 }
 
 func (n *Node) handle(msg *Msg) error {
-	if topic, ok := n.ext[msg.I]; ok {
+	// Check the message before anything acts on it, even for a slot
+	// that has externalized: a malformed message mustn't be reported
+	// as a consensus failure.
+	err := msg.valid()
+	if err != nil {
+		return err
+	}
+
+	topic, err := n.ext.Get(msg.I)
+	if err != nil {
+		return err
+	}
+	if topic != nil {
 		// This node has already externalized a value for the given slot.
 		// Send an EXTERNALIZE message outbound, unless the inbound
 		// message is also EXTERNALIZE.
@@ -164,23 +415,30 @@ func (n *Node) handle(msg *Msg) error {
 			// Double check that the inbound EXTERNALIZE value agrees with
 			// this node.
 			if !ValueEqual(inTopic.C.X, topic.C.X) {
-				n.Logf("inbound message %s disagrees with externalized value %s!", msg, topic.C.X)
-				panic("consensus failure")
+				n.fault(&ConsensusError{
+					I:      msg.I,
+					Local:  NewMsg(n.ID, msg.I, n.Q, topic),
+					Remote: msg,
+				})
 			}
-		} else {
-			n.send <- NewMsg(n.ID, msg.I, n.Q, topic)
+			return nil
 		}
+		return n.transmit(NewMsg(n.ID, msg.I, n.Q, topic))
+	}
+	if n.evicted(msg.I) {
+		// This node has externalized a value for the given slot, but no
+		// longer retains it. Running consensus on the slot again could
+		// decide a different value.
+		n.log(LevelDebug, "dropping message for evicted slot", Field{KeySlot, msg.I}, Field{KeyMsg, msg})
 		return nil
 	}
 
-	s, ok := n.pending[msg.I]
-	if !ok {
-		var err error
-		s, err = newSlot(msg.I, n)
-		if err != nil {
-			panic(fmt.Sprintf("cannot create slot %d: %s", msg.I, err))
-		}
-		n.pending[msg.I] = s
+	s, err := n.slot(msg.I)
+	if errors.Is(err, ErrNoPrev) {
+		return n.bufferFuture(msg)
+	}
+	if err != nil {
+		return err
 	}
 
 	outbound, err := s.handle(msg)
@@ -193,27 +451,165 @@ func (n *Node) handle(msg *Msg) error {
 		return nil
 	}
 
-	if extTopic, ok := outbound.T.(*ExtTopic); ok {
-		// Handling the inbound message resulted in externalizing a value.
-		// We can now save the EXTERNALIZE message and get rid of the Slot
-		// object.
-		n.ext[msg.I] = extTopic
-		delete(n.pending, msg.I)
+	return n.emit(s, outbound)
+}
+
+// Nominate proposes vals as candidates for slot i. It waits until
+// the node has processed the request, so the node must be running
+// (see Run, and StepNominate for nodes driven by Step), and reports whether the node is currently
+// eligible to nominate: that is, whether it has had the highest
+// priority among its neighbors (see Neighbors and Priority) in the
+// current or any earlier nomination round.
+//
+// An eligible node votes to nominate its candidates right away. One
+// that isn't keeps them and offers them in each later round, until
+// it becomes eligible or some value is confirmed nominated. Calling
+// Nominate again for the same slot adds to the node's candidates.
+//
+// It is an error to nominate values for a slot that has already
+// externalized, or for slot i when slot i-1 has not (ErrNoPrev).
+func (n *Node) Nominate(ctx context.Context, i SlotID, vals ...Value) (bool, error) {
+	reply := n.queueNominate(i, vals)
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+
+	case res := <-reply:
+		return res.eligible, res.err
 	}
+}
 
-	n.send <- outbound
-	return nil
+// StepNominate is Nominate for callers that drive the node with Step
+// instead of Run. It queues the request, then steps the node until
+// the request has been processed. Like Step, it must not be called
+// concurrently with Run or Step.
+func (n *Node) StepNominate(i SlotID, vals ...Value) (bool, error) {
+	reply := n.queueNominate(i, vals)
+	for {
+		select {
+		case res := <-reply:
+			return res.eligible, res.err
+
+		default:
+			n.Step()
+		}
+	}
 }
 
-func (n *Node) ping() error {
-	for _, s := range n.pending {
-		for _, msg := range s.M {
-			err := n.handle(msg)
+// Queues a nominate command, returning the channel for its result.
+func (n *Node) queueNominate(i SlotID, vals []Value) <-chan nominateResult {
+	var vs ValueSet
+	for _, v := range vals {
+		vs = vs.Add(v)
+	}
+	reply := make(chan nominateResult, 1)
+	n.cmds.writeHi(&nominateCmd{i: i, vals: vs, reply: reply})
+	return reply
+}
+
+func (n *Node) nominate(i SlotID, vals ValueSet) (bool, error) {
+	topic, err := n.ext.Get(i)
+	if err != nil {
+		return false, err
+	}
+	if topic != nil {
+		return false, fmt.Errorf("slot %d has already externalized", i)
+	}
+	if n.evicted(i) {
+		return false, fmt.Errorf("slot %d: %w", i, ErrEvicted)
+	}
+	s, err := n.slot(i)
+	if err != nil {
+		return false, err
+	}
+	eligible, outbound := s.nominate(vals)
+	s.log(LevelDebug, "nominated", Field{"vals", vals}, Field{"eligible", eligible}, Field{KeyResp, outbound})
+	if outbound == nil {
+		return eligible, nil
+	}
+	return eligible, n.emit(s, outbound)
+}
+
+// ErrEvicted occurs when asking for the value externalized for a
+// slot that the node's ExtStore no longer retains.
+var ErrEvicted = errors.New("externalized value no longer retained")
+
+// Tells whether slot i has externalized but is no longer retained by
+// n's ExtStore. (The caller has already found that ext.Get(i) is
+// nil.) Slots externalize in order, so that is the case for any slot
+// that isn't pending and is no higher than the highest one in the
+// store.
+func (n *Node) evicted(i SlotID) bool {
+	if _, ok := n.pending[i]; ok {
+		return false
+	}
+	return i <= n.ext.Highest()
+}
+
+// Returns the pending slot with the given ID, creating it if
+// necessary.
+func (n *Node) slot(i SlotID) (*Slot, error) {
+	if s, ok := n.pending[i]; ok {
+		return s, nil
+	}
+	s, err := newSlot(i, n)
+	if err != nil {
+		return nil, fmt.Errorf("creating slot %d: %w", i, err)
+	}
+	n.pending[i] = s
+	n.scheduleRebroadcast()
+	n.obs.SlotCreated(i)
+	return s, nil
+}
+
+// Sends a protocol message produced by slot s, recording it as the
+// slot's latest. If there is a persister, the slot's state (including
+// msg) is first recorded durably; if that fails, the message is
+// neither sent nor recorded, so that it is produced again next time.
+func (n *Node) emit(s *Slot, msg *Msg) error {
+	if extTopic, ok := msg.T.(*ExtTopic); ok {
+		// The slot has externalized a value.
+		// We can now save the EXTERNALIZE message and get rid of the Slot
+		// object.
+		if n.persister != nil {
+			err := n.persister.SaveExt(s.ID, extTopic)
 			if err != nil {
-				return err
+				return fmt.Errorf("saving externalized value for slot %d: %w", s.ID, err)
 			}
 		}
+		err := n.ext.Put(s.ID, extTopic)
+		if err != nil {
+			return fmt.Errorf("storing externalized value for slot %d: %w", s.ID, err)
+		}
+		s.close()
+		delete(n.pending, s.ID)
+		if len(n.pending) == 0 {
+			n.cancelRebroadcast()
+		}
+		n.signalExt()
+		n.obs.Externalized(s.ID, extTopic.C)
+		n.replayFuture(s.ID + 1)
+	} else if n.persister != nil {
+		st := s.State()
+		st.Sent = msg
+		err := n.persister.SaveSlot(st)
+		if err != nil {
+			return fmt.Errorf("saving state of slot %d: %w", s.ID, err)
+		}
+	}
+
+	s.sent = msg
+	n.progressed = true
+	return n.transmit(msg)
+}
+
+// Signs msg (if n has a Signer) and queues it for sending (see do).
+func (n *Node) transmit(msg *Msg) error {
+	err := n.sign(msg)
+	if err != nil {
+		return err
 	}
+	n.outbox = append(n.outbox, msg)
 	return nil
 }
 
@@ -229,8 +625,11 @@ func (n *Node) G(i SlotID, m []byte) (result [32]byte, err error) { // xxx unexp
 
 	var prevValBytes []byte
 	if i > 1 {
-		topic, ok := n.ext[i-1]
-		if !ok {
+		topic, err := n.ext.Get(i - 1)
+		if err != nil {
+			return result, err
+		}
+		if topic == nil {
 			return result, ErrNoPrev
 		}
 		prevValBytes = topic.C.X.Bytes()
@@ -245,18 +644,18 @@ func (n *Node) G(i SlotID, m []byte) (result [32]byte, err error) { // xxx unexp
 	return result, nil
 }
 
-// Weight returns the fraction of n's quorum slices in which id
-// appears. Return value is the fraction and (as an optimization) a
-// bool indicating whether it's exactly 1.
-func (n *Node) Weight(id NodeID) (float64, bool) {
+// Weight returns the exact fraction of n's quorum slices in which id
+// appears. (It is 1 for n itself.)
+func (n *Node) Weight(id NodeID) *big.Rat {
 	if id == n.ID {
-		return 1.0, true
+		return big.NewRat(1, 1)
 	}
-	return n.Q.weight(id)
+	return n.Q.Weight(id)
 }
 
 // Peers returns a flattened, uniquified list of the node IDs in n's
-// quorum slices, not including n's own ID.
+// quorum slices, not including n's own ID. It is safe for concurrent
+// use (as long as n.Q isn't modified).
 func (n *Node) Peers() NodeIDSet {
 	return n.Q.Nodes()
 }
@@ -269,18 +668,7 @@ func (n *Node) Neighbors(i SlotID, num int) (NodeIDSet, error) {
 	peers = peers.Add(n.ID)
 	var result NodeIDSet
 	for _, nodeID := range peers {
-		weight64, is1 := n.Weight(nodeID)
-		var hwBytes []byte
-		if is1 {
-			hwBytes = maxUint256[:]
-		} else {
-			w := big.NewFloat(weight64)
-			w.Mul(w, hmax)
-			hwInt, _ := w.Int(nil)
-			hwBytes = hwInt.Bytes()
-		}
-		var hw [32]byte
-		copy(hw[32-len(hwBytes):], hwBytes) // hw is now a big-endian uint256
+		hw := neighborThreshold(n.Weight(nodeID))
 
 		m := new(bytes.Buffer)
 		m.WriteByte('N')
@@ -311,8 +699,11 @@ func (n *Node) Priority(i SlotID, num int, nodeID NodeID) ([32]byte, error) {
 }
 
 // AllKnown gives the complete set of reachable node IDs,
-// excluding n.ID.
+// excluding n.ID. It is safe for concurrent use.
 func (n *Node) AllKnown() NodeIDSet {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
 	result := n.Peers()
 	for _, s := range n.pending {
 		for _, msg := range s.M {
@@ -324,26 +715,143 @@ func (n *Node) AllKnown() NodeIDSet {
 }
 
 // HighestExt returns the ID of the highest slot for which this node
-// has an externalized value.
+// has an externalized value. It is safe for concurrent use.
 func (n *Node) HighestExt() SlotID {
-	var result SlotID
-	for slotID := range n.ext {
-		if slotID > result {
-			result = slotID
+	return n.ext.Highest()
+}
+
+// Externalized returns the value this node externalized for slot i,
+// if it has (and its ExtStore still retains it). It is safe for
+// concurrent use.
+func (n *Node) Externalized(i SlotID) (Value, bool) {
+	topic, err := n.ext.Get(i)
+	if err != nil {
+		n.log(LevelError, "reading externalized value", Field{KeySlot, i}, Field{KeyErr, err})
+		return nil, false
+	}
+	if topic == nil {
+		return nil, false
+	}
+	return topic.C.X, true
+}
+
+// WaitExternalized waits until this node externalizes a value for
+// slot i, then returns it. If the slot has externalized but the
+// node's ExtStore no longer retains it, the error is ErrEvicted. It
+// is safe for concurrent use.
+func (n *Node) WaitExternalized(ctx context.Context, i SlotID) (Value, error) {
+	for {
+		// Get the channel before checking,
+		// so that an externalization in between isn't missed.
+		ch := n.extSignal()
+
+		// Likewise get the highest slot before looking for slot i:
+		// if that's at least i and slot i is then missing,
+		// it was evicted rather than not yet externalized.
+		highest := n.ext.Highest()
+
+		topic, err := n.ext.Get(i)
+		if err != nil {
+			return nil, err
+		}
+		if topic != nil {
+			return topic.C.X, nil
+		}
+		if i <= highest {
+			return nil, fmt.Errorf("slot %d: %w", i, ErrEvicted)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ch:
 		}
 	}
-	return result
+}
+
+// ExtValue is a value externalized for a slot.
+type ExtValue struct {
+	I SlotID
+	V Value
+}
+
+// ExtValues produces a channel of the values this node externalizes,
+// in slot order, starting with slot from. A slot is delivered only
+// after all the slots before it, even if it externalizes first.
+// Slots the node's ExtStore no longer retains are skipped, so the
+// stream has a gap (visible in ExtValue.I) when it starts before the
+// lowest retained slot or when the reader falls so far behind that
+// the store evicts slots not yet delivered. The channel is closed
+// when ctx is canceled.
+func (n *Node) ExtValues(ctx context.Context, from SlotID) <-chan ExtValue {
+	ch := make(chan ExtValue)
+	go func() {
+		defer close(ch)
+
+		for i := from; ; i++ {
+			v, err := n.WaitExternalized(ctx, i)
+			for errors.Is(err, ErrEvicted) {
+				// Skip ahead to the lowest slot still retained.
+				if i, err = n.lowestExt(i); err == nil {
+					v, err = n.WaitExternalized(ctx, i)
+				}
+			}
+			if err != nil {
+				if ctx.Err() == nil {
+					n.log(LevelError, "reading externalized values", Field{KeyErr, err})
+				}
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- ExtValue{I: i, V: v}:
+			}
+		}
+	}()
+	return ch
+}
+
+// Returns the lowest slot after from that the node's ExtStore
+// retains, or the slot after the highest if it retains none.
+func (n *Node) lowestExt(from SlotID) (SlotID, error) {
+	highest := n.ext.Highest()
+	result := highest + 1
+	err := n.ext.Range(from+1, highest, func(slotID SlotID, _ *ExtTopic) bool {
+		result = slotID
+		return false
+	})
+	return result, err
+}
+
+// Returns a channel that is closed
+// the next time this node externalizes a value.
+func (n *Node) extSignal() <-chan struct{} {
+	n.extMu.Lock()
+	defer n.extMu.Unlock()
+	if n.extCh == nil {
+		n.extCh = make(chan struct{})
+	}
+	return n.extCh
+}
+
+// Wakes callers of WaitExternalized.
+func (n *Node) signalExt() {
+	n.extMu.Lock()
+	defer n.extMu.Unlock()
+	if n.extCh != nil {
+		close(n.extCh)
+		n.extCh = nil
+	}
 }
 
 // MsgsSince returns all this node's messages with slotID > since.
+// It is safe for concurrent use.
 // TODO: need a better interface, this list could get hella big.
 func (n *Node) MsgsSince(since SlotID) []*Msg {
 	var result []*Msg
 
-	for slotID, topic := range n.ext {
-		if slotID <= since {
-			continue
-		}
+	err := n.ext.Range(since+1, n.ext.Highest(), func(slotID SlotID, topic *ExtTopic) bool {
 		msg := &Msg{
 			V: n.ID,
 			I: slotID,
@@ -351,21 +859,37 @@ func (n *Node) MsgsSince(since SlotID) []*Msg {
 			T: topic,
 		}
 		result = append(result, msg)
+		return true
+	})
+	if err != nil {
+		n.log(LevelError, "reading externalized values", Field{KeyErr, err})
 	}
+
+	n.mu.Lock()
 	for slotID, slot := range n.pending {
 		if slotID <= since {
 			continue
 		}
 		result = append(result, slot.Msg())
 	}
+	n.mu.Unlock()
+
+	for _, msg := range result {
+		if msg == nil {
+			continue
+		}
+		err := n.sign(msg)
+		if err != nil {
+			n.log(LevelError, "signing message", Field{KeySlot, msg.I}, Field{KeyErr, err})
+		}
+	}
 	return result
 }
 
-// Logf produces log output prefixed with the node's identity.
+// Logf sends a formatted message to the node's Logger (see
+// WithLogger) at LevelInfo.
 func (n *Node) Logf(f string, a ...interface{}) {
-	f = "node %s: " + f
-	a = append([]interface{}{n.ID}, a...)
-	log.Printf(f, a...)
+	n.log(LevelInfo, fmt.Sprintf(f, a...))
 }
 
 var maxUint256 = [32]byte{
@@ -375,12 +899,20 @@ var maxUint256 = [32]byte{
 	0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
 }
 
-// maxuint256, as a float
-var hmax *big.Float
-
-func init() {
-	hmaxInt := new(big.Int)
-	hmaxInt.SetBytes(maxUint256[:])
-	hmax = new(big.Float)
-	hmax.SetInt(hmaxInt)
+// maxuint256, as a big.Int
+var hmax = new(big.Int).SetBytes(maxUint256[:])
+
+// Computes floor(w * (2^256-1)) for a weight w in [0,1],
+// as a big-endian uint256.
+// A node is a neighbor when its hash is less than this.
+func neighborThreshold(w *big.Rat) [32]byte {
+	var result [32]byte
+	t := new(big.Int).Mul(hmax, w.Num())
+	t.Quo(t, w.Denom())
+	if t.Cmp(hmax) > 0 {
+		t = hmax
+	}
+	b := t.Bytes()
+	copy(result[32-len(b):], b)
+	return result
 }
@@ -1,10 +1,16 @@
 package scp
 
 import (
+	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"math/big"
 	"reflect"
+	"runtime"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestPeers(t *testing.T) {
@@ -38,7 +44,7 @@ func TestPeers(t *testing.T) {
 				q = append(q, ns)
 			}
 			ch := make(chan *Msg)
-			n := NewNode("x", slicesToQSet(q), ch, nil)
+			n := NewNode("x", slicesToQSet(q), ch)
 			got := n.Peers()
 			want := toNodeIDSet(tc.want)
 			if !reflect.DeepEqual(got, NodeIDSet(want)) {
@@ -48,26 +54,66 @@ func TestPeers(t *testing.T) {
 	}
 }
 
+func TestNodeFrac(t *testing.T) {
+	q := QSet{T: 2, M: []QSetMember{{N: nodeIDPtr("a")}, {N: nodeIDPtr("b")}, {N: nodeIDPtr("c")}}}
+	if num, denom := q.NodeFrac("a"); num != 2 || denom != 3 {
+		t.Errorf("got %d/%d, want 2/3", num, denom)
+	}
+
+	// 1 of {a, 30 of 70 others}: there are 1+C(70,30) slices,
+	// more than an int64 can count.
+	inner := QSet{T: 30}
+	for i := 0; i < 70; i++ {
+		inner.M = append(inner.M, QSetMember{N: nodeIDPtr(fmt.Sprintf("n%d", i))})
+	}
+	q = QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("a")}, {Q: &inner}}}
+	num, denom := q.NodeFrac("n0")
+	if num <= 0 || num >= denom {
+		t.Fatalf("got %d/%d, want a fraction strictly between 0 and 1", num, denom)
+	}
+	got := big.NewRat(int64(num), int64(denom))
+	diff := new(big.Rat).Sub(q.Weight("n0"), got)
+	if diff.Sign() < 0 || diff.Cmp(big.NewRat(1, int64(denom))) >= 0 {
+		t.Errorf("got %d/%d, too far from %s", num, denom, q.Weight("n0"))
+	}
+}
+
 func TestWeight(t *testing.T) {
 	cases := []struct {
-		slices  []string
-		wantW   float64
-		wantIs1 bool
+		slices []string
+		id     NodeID
+		want   string
 	}{
 		{
 			slices: []string{"a"},
+			id:     "z",
+			want:   "0",
 		},
 		{
 			slices: []string{"a", "b"},
+			id:     "z",
+			want:   "0",
 		},
 		{
 			slices: []string{"a b", "a z"},
-			wantW:  0.5,
+			id:     "z",
+			want:   "1/2",
+		},
+		{
+			slices: []string{"a b", "a c", "a d", "a z"},
+			id:     "z",
+			want:   "1/4",
+		},
+		{
+			// a appears in every slice.
+			slices: []string{"a b", "a c", "a d", "a z"},
+			id:     "a",
+			want:   "1",
 		},
 		{
-			slices:  []string{"a b", "a c", "a d", "a z"},
-			wantW:   0.25,
-			wantIs1: false,
+			slices: []string{"a b", "c d", "a d"},
+			id:     "d",
+			want:   "2/3",
 		},
 	}
 	for i, tc := range cases {
@@ -78,14 +124,144 @@ func TestWeight(t *testing.T) {
 				q = append(q, ns)
 			}
 			ch := make(chan *Msg)
-			n := NewNode("x", slicesToQSet(q), ch, nil)
-			_, is1 := n.Weight(n.ID)
-			if !is1 {
-				t.Errorf("got !is1, want is1 for n.Weight(n.ID)")
+			n := NewNode("x", slicesToQSet(q), ch)
+			if got := n.Weight(n.ID); got.Cmp(big.NewRat(1, 1)) != 0 {
+				t.Errorf("got %s for n.Weight(n.ID), want 1", got)
+			}
+			want, _ := new(big.Rat).SetString(tc.want)
+			if got := n.Weight(tc.id); got.Cmp(want) != 0 {
+				t.Errorf("got %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestQSetWeight(t *testing.T) {
+	a, b, c, d := nodeIDPtr("a"), nodeIDPtr("b"), nodeIDPtr("c"), nodeIDPtr("d")
+	cases := []struct {
+		q         QSet
+		id        NodeID
+		want      string
+		numSlices int64
+	}{
+		{
+			q:         QSet{T: 2, M: []QSetMember{{N: a}, {N: b}, {N: c}}},
+			id:        "a",
+			want:      "2/3",
+			numSlices: 3,
+		},
+		{
+			// Slices: {a b} {a c} {a d} {b c} {b d}.
+			// The inner set counts once per sub-slice.
+			// (Stellar-core's formula would give 1/3; see QSet.Weight.)
+			q:         QSet{T: 2, M: []QSetMember{{N: a}, {N: b}, {Q: &QSet{T: 1, M: []QSetMember{{N: c}, {N: d}}}}}},
+			id:        "c",
+			want:      "2/5",
+			numSlices: 5,
+		},
+		{
+			q:         QSet{T: 2, M: []QSetMember{{N: a}, {N: b}, {Q: &QSet{T: 1, M: []QSetMember{{N: c}, {N: d}}}}}},
+			id:        "a",
+			want:      "3/5",
+			numSlices: 5,
+		},
+		{
+			// a is in both inner sets, so it's in every slice.
+			q: QSet{T: 1, M: []QSetMember{
+				{Q: &QSet{T: 2, M: []QSetMember{{N: a}, {N: b}}}},
+				{Q: &QSet{T: 2, M: []QSetMember{{N: a}, {N: c}}}},
+			}},
+			id:        "a",
+			want:      "1",
+			numSlices: 2,
+		},
+		{
+			q:         QSet{T: 1, M: []QSetMember{{N: a}}},
+			id:        "z",
+			want:      "0",
+			numSlices: 1,
+		},
+	}
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%02d", i+1), func(t *testing.T) {
+			want, _ := new(big.Rat).SetString(tc.want)
+			if got := tc.q.Weight(tc.id); got.Cmp(want) != 0 {
+				t.Errorf("got weight %s, want %s", got, want)
+			}
+			if got := tc.q.NumSlices(); got.Int64() != tc.numSlices {
+				t.Errorf("got %s slices, want %d", got, tc.numSlices)
+			}
+			var count int64
+			tc.q.Slices(func(NodeIDSet) bool {
+				count++
+				return true
+			})
+			if count != tc.numSlices {
+				t.Errorf("Slices produced %d slices, want %d", count, tc.numSlices)
+			}
+		})
+	}
+}
+
+func TestNeighborThreshold(t *testing.T) {
+	cases := []struct {
+		w    string
+		want string
+	}{
+		{"0", "0000000000000000000000000000000000000000000000000000000000000000"},
+		{"1", "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"},
+		{"1/2", "7fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"},
+		{"1/3", "5555555555555555555555555555555555555555555555555555555555555555"},
+		{"2/3", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"},
+		{"2/5", "6666666666666666666666666666666666666666666666666666666666666666"},
+		{"1/7", "2492492492492492492492492492492492492492492492492492492492492492"},
+	}
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%02d", i+1), func(t *testing.T) {
+			w, _ := new(big.Rat).SetString(tc.w)
+			got := neighborThreshold(w)
+			if h := hex.EncodeToString(got[:]); h != tc.want {
+				t.Errorf("got %s, want %s", h, tc.want)
+			}
+		})
+	}
+}
+
+func TestNeighbors(t *testing.T) {
+	q := QSet{T: 2, M: []QSetMember{
+		{N: nodeIDPtr("a")},
+		{N: nodeIDPtr("b")},
+		{Q: &QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("c")}, {N: nodeIDPtr("d")}}}},
+	}}
+	ext := NewMemExtStore(0)
+	err := ext.Put(1, &ExtTopic{C: Ballot{1, valtype(17)}, HN: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := NewNode("x", q, nil, WithExtStore(ext))
+
+	// These are fixed vectors: any change to the hashing or the
+	// weight computation changes them, and must be made on purpose.
+	cases := []struct {
+		slotID SlotID
+		round  int
+		want   string
+	}{
+		{1, 1, "a d x"},
+		{1, 2, "a x"},
+		{1, 3, "b d x"},
+		{2, 1, "a b d x"},
+		{2, 2, "b x"},
+		{2, 3, "b x"},
+	}
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%02d", i+1), func(t *testing.T) {
+			got, err := n.Neighbors(tc.slotID, tc.round)
+			if err != nil {
+				t.Fatal(err)
 			}
-			got, is1 := n.Weight("z")
-			if got != tc.wantW || is1 != tc.wantIs1 {
-				t.Errorf("got %f (%v), want %f (%v)", got, is1, tc.wantW, tc.wantIs1)
+			if want := toNodeIDSet(tc.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
 			}
 		})
 	}
@@ -112,3 +288,471 @@ func slicesToQSet(slices []NodeIDSet) QSet {
 	}
 	return result
 }
+
+// Calls n.handle and sends the resulting messages, as Step would.
+func handleNow(n *Node, msg *Msg) error {
+	err := n.handle(msg)
+	n.flush()
+	return err
+}
+
+func TestFutureSlots(t *testing.T) {
+	ch := make(chan *Msg, 10)
+	q := QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("y")}}}
+	yQ := QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("x")}}}
+	n := NewNode("x", q, ch, WithConfig(NodeConfig{FutureSlotWindow: 3, MaxFutureMsgs: 2}))
+
+	// Slot 2 can't be created before slot 1 externalizes.
+	// Its messages are buffered, not fatal.
+	err := handleNow(n, NewMsg("y", 2, yQ, &PrepTopic{B: Ballot{1, valtype(8)}, P: Ballot{1, valtype(8)}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = handleNow(n, NewMsg("z", 2, yQ, &NomTopic{X: ValueSet{valtype(9)}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(n.pending) != 0 {
+		t.Errorf("got %d pending slot(s), want 0", len(n.pending))
+	}
+
+	// Slot 3 is in the window, but the buffer is full.
+	err = handleNow(n, NewMsg("y", 3, yQ, &NomTopic{X: ValueSet{valtype(9)}}))
+	if !errors.Is(err, ErrFutureFull) {
+		t.Errorf("got error %v, want %s", err, ErrFutureFull)
+	}
+
+	// Slot 4 is outside the window.
+	err = handleNow(n, NewMsg("y", 4, yQ, &NomTopic{X: ValueSet{valtype(9)}}))
+	if !errors.Is(err, ErrFutureSlot) {
+		t.Errorf("got error %v, want %s", err, ErrFutureSlot)
+	}
+
+	// Now slot 1 externalizes, and the buffered slot-2 messages are
+	// replayed.
+	err = handleNow(n, NewMsg("y", 1, yQ, &ExtTopic{C: Ballot{1, valtype(7)}, HN: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := <-ch
+	if sent.I != 1 {
+		t.Fatalf("got message for slot %d, want 1", sent.I)
+	}
+	for n.Step() {
+	}
+	if n.numFuture != 0 {
+		t.Errorf("got %d buffered message(s), want 0", n.numFuture)
+	}
+	s := n.pending[2]
+	if s == nil {
+		t.Fatal("slot 2 not created")
+	}
+	if s.M["y"] == nil || s.M["z"] == nil {
+		t.Errorf("buffered messages not replayed (got %v)", s.M)
+	}
+}
+
+func TestEvictedSlot(t *testing.T) {
+	ch := make(chan *Msg, 10)
+	q := QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("y")}}}
+	yQ := QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("x")}}}
+	n := NewNode("x", q, ch, WithExtStore(NewMemExtStore(1)))
+
+	for i := SlotID(1); i <= 2; i++ {
+		err := handleNow(n, NewMsg("y", i, yQ, &ExtTopic{C: Ballot{1, valtype(7)}, HN: 1}))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := n.HighestExt(); got != 2 {
+		t.Fatalf("got highest externalized slot %d, want 2", got)
+	}
+	for len(ch) > 0 {
+		<-ch
+	}
+
+	// Slot 1 is no longer retained. Late messages for it must not start
+	// consensus on it again.
+	msgs := []*Msg{
+		NewMsg("y", 1, yQ, &NomTopic{X: ValueSet{valtype(8)}}),
+		NewMsg("y", 1, yQ, &PrepTopic{B: Ballot{1, valtype(8)}}),
+		NewMsg("y", 1, yQ, &ExtTopic{C: Ballot{1, valtype(8)}, HN: 1}),
+	}
+	for _, msg := range msgs {
+		if err := handleNow(n, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(n.pending) != 0 {
+		t.Errorf("got %d pending slot(s), want 0", len(n.pending))
+	}
+	if len(ch) > 0 {
+		t.Errorf("sent %s", <-ch)
+	}
+
+	if _, err := n.StepNominate(1, valtype(8)); !errors.Is(err, ErrEvicted) {
+		t.Errorf("got error %v, want %s", err, ErrEvicted)
+	}
+}
+
+func TestNominate(t *testing.T) {
+	q := QSet{T: 2, M: []QSetMember{{N: nodeIDPtr("a")}, {N: nodeIDPtr("b")}, {N: nodeIDPtr("c")}}}
+	ext := NewMemExtStore(0)
+	for i := SlotID(1); i < 20; i++ {
+		err := ext.Put(i, &ExtTopic{C: Ballot{1, valtype(i)}, HN: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	clock := NewFakeClock(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC))
+	ch := make(chan *Msg, 10)
+	// Rebroadcasts would interleave with the messages checked below.
+	n := NewNode("x", q, ch, WithExtStore(ext), WithClock(clock), WithConfig(NodeConfig{RebroadcastInterval: -1}))
+
+	// Messages from self are rejected.
+	if err := n.Handle(NewMsg("x", 20, q, &NomTopic{X: ValueSet{valtype(1)}})); err == nil {
+		t.Error("self message accepted")
+	}
+	if n.Step() {
+		t.Error("self message was queued")
+	}
+
+	if _, err := n.StepNominate(19, valtype(1)); err == nil {
+		t.Error("nominated for an externalized slot")
+	}
+	if _, err := n.StepNominate(21, valtype(1)); !errors.Is(err, ErrNoPrev) {
+		t.Errorf("got error %v, want ErrNoPrev", err)
+	}
+
+	// Find a slot in which x is not eligible in the first round.
+	var (
+		slotID   SlotID
+		eligible bool
+	)
+	for slotID = 20; ; slotID++ {
+		if slotID > 20 {
+			err := ext.Put(slotID-1, &ExtTopic{C: Ballot{1, valtype(slotID - 1)}, HN: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		var err error
+		eligible, err = n.StepNominate(slotID, valtype(100))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := n.pending[slotID]
+		if eligible != s.maxPriPeers.Contains("x") {
+			t.Fatalf("slot %d: got eligible %v, but maxPriPeers is %v", slotID, eligible, s.maxPriPeers)
+		}
+		if eligible {
+			msg := <-ch
+			if topic, ok := msg.T.(*NomTopic); !ok || !topic.X.Contains(valtype(100)) {
+				t.Fatalf("slot %d: got %s, want a nomination of 100", slotID, msg)
+			}
+			continue
+		}
+		if len(s.X) > 0 {
+			t.Fatalf("slot %d: ineligible node voted for %v", slotID, s.X)
+		}
+		break
+	}
+
+	// Run nomination rounds until x is eligible.
+	// It should then offer its candidate.
+	s := n.pending[slotID]
+	for !s.maxPriPeers.Contains("x") {
+		next, ok := clock.Next()
+		if !ok {
+			t.Fatal("no timers")
+		}
+		clock.AdvanceTo(next)
+		for n.Step() {
+		}
+		if s.Round() > 100 {
+			t.Fatal("x never became eligible")
+		}
+	}
+	if !s.X.Contains(valtype(100)) {
+		t.Errorf("round %d: x is eligible but did not vote for its candidate (X is %v)", s.Round(), s.X)
+	}
+	select {
+	case msg := <-ch:
+		if topic, ok := msg.T.(*NomTopic); !ok || !topic.X.Contains(valtype(100)) {
+			t.Errorf("got %s, want a nomination of 100", msg)
+		}
+	default:
+		t.Error("no nomination sent")
+	}
+}
+
+func TestWaitExternalized(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	q := slicesToQSet([]NodeIDSet{toNodeIDSet("y")})
+	yQ := slicesToQSet([]NodeIDSet{toNodeIDSet("x")})
+	ch := make(chan *Msg, 10)
+	n := NewNode("x", q, ch)
+	go n.Run(ctx)
+
+	if _, ok := n.Externalized(1); ok {
+		t.Fatal("slot 1 externalized too early")
+	}
+	shortCtx, shortCancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer shortCancel()
+	if _, err := n.WaitExternalized(shortCtx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want context.DeadlineExceeded", err)
+	}
+
+	stream := n.ExtValues(ctx, 1)
+
+	type result struct {
+		v   Value
+		err error
+	}
+	waited := make(chan result, 1)
+	go func() {
+		v, err := n.WaitExternalized(ctx, 2)
+		waited <- result{v: v, err: err}
+	}()
+
+	for i := SlotID(1); i <= 3; i++ {
+		// Node y, the only member of x's slice, has externalized.
+		n.Handle(NewMsg("y", i, yQ, &ExtTopic{C: Ballot{1, valtype(10 + i)}, HN: 1}))
+
+		got := <-stream
+		if want := (ExtValue{I: i, V: valtype(10 + i)}); got != want {
+			t.Errorf("got %v from stream, want %v", got, want)
+		}
+		if v, ok := n.Externalized(i); !ok || v != valtype(10+i) {
+			t.Errorf("slot %d: got %v, %v", i, v, ok)
+		}
+	}
+
+	res := <-waited
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if res.v != valtype(12) {
+		t.Errorf("got %v, want 12", res.v)
+	}
+
+	// A stream can start with slots already externalized.
+	ctx2, cancel2 := context.WithCancel(ctx)
+	defer cancel2()
+	var got []ExtValue
+	for ev := range n.ExtValues(ctx2, 2) {
+		got = append(got, ev)
+		if ev.I == 3 {
+			cancel2()
+		}
+	}
+	want := []ExtValue{{I: 2, V: valtype(12)}, {I: 3, V: valtype(13)}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestExtValuesEvicted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	q := slicesToQSet([]NodeIDSet{toNodeIDSet("y")})
+	yQ := slicesToQSet([]NodeIDSet{toNodeIDSet("x")})
+	ch := make(chan *Msg, 10)
+	n := NewNode("x", q, ch, WithExtStore(NewMemExtStore(2)))
+	go n.Run(ctx)
+
+	externalize := func(i SlotID) {
+		n.Handle(NewMsg("y", i, yQ, &ExtTopic{C: Ballot{1, valtype(10 + i)}, HN: 1}))
+		if _, err := n.WaitExternalized(ctx, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stream := n.ExtValues(ctx, 1)
+	externalize(1)
+	if got, want := <-stream, (ExtValue{I: 1, V: valtype(11)}); got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	// The reader falls behind while slots 2 and 3 are evicted.
+	for i := SlotID(2); i <= 5; i++ {
+		externalize(i)
+	}
+	for _, i := range []SlotID{1, 3} {
+		if _, err := n.WaitExternalized(ctx, i); !errors.Is(err, ErrEvicted) {
+			t.Errorf("slot %d: got error %v, want ErrEvicted", i, err)
+		}
+	}
+
+	// Before slot 4 externalized, the stream may have fetched slot 2
+	// or, once slot 2 was evicted, skipped to slot 3 and fetched that.
+	// Either way it holds at most one value, then skips to slot 4.
+	var got []ExtValue
+	for ev := range stream {
+		got = append(got, ev)
+		if ev.I >= 5 {
+			cancel()
+		}
+	}
+	tail := []ExtValue{{I: 4, V: valtype(14)}, {I: 5, V: valtype(15)}}
+	var ok bool
+	switch len(got) {
+	case 2:
+		ok = reflect.DeepEqual(got, tail)
+	case 3:
+		ok = (got[0].I == 2 || got[0].I == 3) && got[0].V == valtype(10+got[0].I) && reflect.DeepEqual(got[1:], tail)
+	}
+	if !ok {
+		t.Errorf("got %v, want %v, possibly preceded by slot 2 or 3", got, tail)
+	}
+}
+
+func TestDelayStep(t *testing.T) {
+	clock := NewFakeClock(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC))
+	ch := make(chan *Msg, 10)
+	q := QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("y")}}}
+	yQ := QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("x")}}}
+	n := NewNode("x", q, ch, WithClock(clock))
+
+	n.Delay(1000)
+	if err := n.Handle(NewMsg("y", 1, yQ, &NomTopic{X: ValueSet{valtype(7)}})); err != nil {
+		t.Fatal(err)
+	}
+	if !n.Step() {
+		t.Fatal("no delay command queued")
+	}
+
+	// The message is held until the clock reaches the end of the
+	// delay, without Step blocking, and the node still answers
+	// queries.
+	if n.Step() {
+		t.Fatal("message handled during delay")
+	}
+	if len(n.Status().Slots) != 0 {
+		t.Fatal("slot created during delay")
+	}
+	clock.Advance(999 * time.Millisecond)
+	if n.Step() {
+		t.Fatal("message handled before delay ended")
+	}
+
+	clock.Advance(time.Millisecond)
+	for n.Step() {
+	}
+	if len(n.Status().Slots) == 0 {
+		t.Error("message not handled after delay")
+	}
+
+	// A second delay during the first extends it.
+	n.Delay(1000)
+	n.Delay(500)
+	if err := n.Handle(NewMsg("y", 2, yQ, &NomTopic{X: ValueSet{valtype(8)}})); err != nil {
+		t.Fatal(err)
+	}
+	for n.Step() {
+	}
+	clock.Advance(1499 * time.Millisecond)
+	for n.Step() {
+	}
+	if got := n.QueueStats().Depth; got != 1 {
+		t.Fatalf("got queue depth %d after 1499ms, want 1", got)
+	}
+	clock.Advance(time.Millisecond)
+	for n.Step() {
+	}
+	if got := n.QueueStats().Depth; got != 0 {
+		t.Errorf("got queue depth %d after 1500ms, want 0", got)
+	}
+}
+
+func TestSlotClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	clock := NewFakeClock(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC))
+	ch := make(chan *Msg, 10)
+	q := QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("y")}}}
+	yQ := QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("x")}}}
+	n := NewNode("x", q, ch, WithClock(clock))
+
+	for _, peerID := range []NodeID{"z", "y"} {
+		err := handleNow(n, NewMsg(peerID, 1, yQ, &NomTopic{X: ValueSet{valtype(7)}}))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for len(ch) > 0 {
+		<-ch
+	}
+	// The slot's nomination-round timer and the node's rebroadcast
+	// timer.
+	if got := clock.Pending(); got != 2 {
+		t.Fatalf("got %d pending timer(s), want 2", got)
+	}
+
+	// Fire the timers, then externalize the slot before the resulting
+	// commands are processed.
+	clock.Advance(3 * n.Config().NomRoundInterval)
+	s := n.pending[1]
+	err := handleNow(n, NewMsg("y", 1, yQ, &ExtTopic{C: Ballot{1, valtype(7)}, HN: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-ch
+	if got := clock.Pending(); got != 0 {
+		t.Errorf("after externalizing, got %d pending timer(s), want 0", got)
+	}
+
+	// Commands queued for the closed slot are ignored.
+	// (Rehandling z's message would send it an EXTERNALIZE.)
+	n.rehandle(s)
+	for n.Step() {
+	}
+	if len(ch) > 0 {
+		t.Errorf("closed slot sent %s", <-ch)
+	}
+
+	// When Run exits, it stops the timers of the remaining slots.
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		n.Run(ctx)
+		close(done)
+	}()
+	for i := SlotID(2); i <= 3; i++ {
+		if i > 2 {
+			n.ext.Put(i-1, &ExtTopic{C: Ballot{1, valtype(7)}, HN: 1})
+		}
+		n.Handle(NewMsg("y", i, yQ, &NomTopic{X: ValueSet{valtype(7)}}))
+		for len(n.Status().Slots) < int(i-1) {
+			runtime.Gosched()
+		}
+	}
+	if got := clock.Pending(); got != 3 {
+		t.Errorf("got %d pending timer(s), want 3", got)
+	}
+	cancel()
+	<-done
+
+	if got := clock.Pending(); got != 0 {
+		t.Errorf("after Run, got %d pending timer(s), want 0", got)
+	}
+	if got := len(n.Status().Slots); got != 2 {
+		t.Errorf("after Run, got %d slot(s), want 2", got)
+	}
+
+	// Give exiting goroutines a moment.
+	var after int
+	for i := 0; i < 100; i++ {
+		after = runtime.NumGoroutine()
+		if after <= before {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if after > before {
+		t.Errorf("got %d goroutines, want %d", after, before)
+	}
+}
@@ -0,0 +1,107 @@
+package scp
+
+import "time"
+
+// Observer receives notifications about the progress of a node's
+// slots. Callbacks are made on the goroutine processing the node's
+// events (see Node.Run and Node.Step), after the slot's state has
+// changed, so they must return promptly and must not call back into
+// the node.
+//
+// Embed NopObserver in an implementation to ignore the callbacks it
+// doesn't care about.
+type Observer interface {
+	// SlotCreated is called when a node begins working on slot i.
+	SlotCreated(i SlotID)
+
+	// NomRound is called when slot i enters a new nomination round
+	// (other than the first, which begins when the slot is created).
+	NomRound(i SlotID, round int)
+
+	// VotedNominated, AcceptedNominated, and ConfirmedNominated are
+	// called with the values newly voted nominated, accepted
+	// nominated, and confirmed nominated in slot i.
+	VotedNominated(i SlotID, vals ValueSet)
+	AcceptedNominated(i SlotID, vals ValueSet)
+	ConfirmedNominated(i SlotID, vals ValueSet)
+
+	// PhaseChanged is called when slot i moves from one phase to
+	// another. B is the slot's current ballot.
+	PhaseChanged(i SlotID, from, to Phase, b Ballot)
+
+	// BallotBumped is called when the current ballot of slot i
+	// changes.
+	BallotBumped(i SlotID, from, to Ballot)
+
+	// TimerArmed is called when a deferred-update timer is armed for
+	// slot i, whose current ballot is b. The timer fires after d.
+	TimerArmed(i SlotID, b Ballot, d time.Duration)
+
+	// Externalized is called when slot i externalizes the value of
+	// ballot c.
+	Externalized(i SlotID, c Ballot)
+}
+
+// NopObserver is an Observer that ignores all notifications.
+type NopObserver struct{}
+
+var _ Observer = NopObserver{}
+
+func (NopObserver) SlotCreated(SlotID)                        {}
+func (NopObserver) NomRound(SlotID, int)                      {}
+func (NopObserver) VotedNominated(SlotID, ValueSet)           {}
+func (NopObserver) AcceptedNominated(SlotID, ValueSet)        {}
+func (NopObserver) ConfirmedNominated(SlotID, ValueSet)       {}
+func (NopObserver) PhaseChanged(SlotID, Phase, Phase, Ballot) {}
+func (NopObserver) BallotBumped(SlotID, Ballot, Ballot)       {}
+func (NopObserver) TimerArmed(SlotID, Ballot, time.Duration)  {}
+func (NopObserver) Externalized(SlotID, Ballot)               {}
+
+// WithObserver makes a node report the progress of its slots to o.
+func WithObserver(o Observer) NodeOption {
+	return func(n *Node) {
+		n.obs = o
+	}
+}
+
+// The parts of a slot's state that are reported to the node's
+// Observer when they change.
+type slotSnapshot struct {
+	ph      Phase
+	b       Ballot
+	x, y, z ValueSet
+}
+
+func (s *Slot) snapshot() slotSnapshot {
+	return slotSnapshot{
+		ph: s.Ph,
+		b:  s.B,
+		x:  s.X,
+		y:  s.Y,
+		z:  s.Z,
+	}
+}
+
+// Reports to the node's Observer any changes to s since before was
+// taken.
+func (s *Slot) notify(before slotSnapshot) {
+	obs := s.V.obs
+
+	// A value promoted from X to Y in one step was still voted for.
+	voted := s.X.Union(s.Y).Minus(before.x.Union(before.y))
+	if len(voted) > 0 {
+		obs.VotedNominated(s.ID, voted)
+	}
+	if accepted := s.Y.Minus(before.y); len(accepted) > 0 {
+		obs.AcceptedNominated(s.ID, accepted)
+	}
+	if confirmed := s.Z.Minus(before.z); len(confirmed) > 0 {
+		obs.ConfirmedNominated(s.ID, confirmed)
+	}
+	if !BallotEqual(s.B, before.b) {
+		obs.BallotBumped(s.ID, before.b, s.B)
+	}
+	if s.Ph != before.ph {
+		obs.PhaseChanged(s.ID, before.ph, s.Ph, s.B)
+	}
+}
@@ -0,0 +1,303 @@
+package scp
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Persister durably records a node's state so that it can be
+// restored (with RestoreNode) after a restart. A node that has
+// prepared or committed to a ballot and then forgets it may
+// contradict itself, which is unsafe.
+//
+// A node with a persister saves a slot's state before sending any
+// protocol message produced by that slot.
+type Persister interface {
+	// SaveSlot records the state of a pending slot, replacing any
+	// state previously saved for the same slot.
+	SaveSlot(*SlotState) error
+
+	// SaveExt records the externalized value for a slot. It also
+	// discards any state saved for the slot with SaveSlot.
+	SaveExt(SlotID, *ExtTopic) error
+
+	// Load returns all saved pending-slot states and externalized
+	// values.
+	Load() ([]*SlotState, map[SlotID]*ExtTopic, error)
+}
+
+// SlotState is the persistent state of a Slot.
+type SlotState struct {
+	ID   SlotID
+	Ph   Phase
+	M    map[NodeID]*Msg
+	Sent *Msg
+
+	T       time.Time
+	X, Y, Z ValueSet
+	Cands   ValueSet
+
+	MaxPriPeers NodeIDSet
+	LastRound   int
+
+	B, P, PP, C, H Ballot
+}
+
+// State produces a snapshot of the slot's persistent state.
+func (s *Slot) State() *SlotState {
+	m := make(map[NodeID]*Msg, len(s.M))
+	for k, v := range s.M {
+		m[k] = v
+	}
+	return &SlotState{
+		ID:          s.ID,
+		Ph:          s.Ph,
+		M:           m,
+		Sent:        s.sent,
+		T:           s.T,
+		X:           s.X,
+		Y:           s.Y,
+		Z:           s.Z,
+		Cands:       s.cands,
+		MaxPriPeers: s.maxPriPeers,
+		LastRound:   s.lastRound,
+		B:           s.B,
+		P:           s.P,
+		PP:          s.PP,
+		C:           s.C,
+		H:           s.H,
+	}
+}
+
+func restoreSlot(n *Node, st *SlotState) *Slot {
+	s := &Slot{
+		ID:          st.ID,
+		V:           n,
+		Ph:          st.Ph,
+		M:           st.M,
+		sent:        st.Sent,
+		T:           st.T,
+		X:           st.X,
+		Y:           st.Y,
+		Z:           st.Z,
+		cands:       st.Cands,
+		maxPriPeers: st.MaxPriPeers,
+		lastRound:   st.LastRound,
+		B:           st.B,
+		P:           st.P,
+		PP:          st.PP,
+		C:           st.C,
+		H:           st.H,
+	}
+	if s.M == nil {
+		s.M = make(map[NodeID]*Msg)
+	}
+
+	// Timers aren't saved, so re-arm the ones the slot's state calls
+	// for.
+	if s.isNomPhase() {
+		s.scheduleRound()
+	}
+	if s.isPrepPhase() || s.Ph == PhCommit {
+		s.maybeScheduleUpd()
+
+		// A blocking set with a higher ballot counter than the slot's
+		// means updateB wanted to raise it but was held to the limit
+		// (see maxBallotCounter), and would have armed a timer for when
+		// it may be raised.
+		bn := s.B.N
+		nodeIDs := s.findBlockingSet(keyedPred("bN>", strconv.Itoa(bn), func(msg *Msg) bool {
+			return msg.bN() > bn
+		}))
+		if len(nodeIDs) > 0 {
+			s.scheduleBN(bn + 1)
+		}
+	}
+	return s
+}
+
+// RestoreNode produces a new node whose pending slots and
+// externalized values are loaded from p. The node continues to record
+// its state in p as it runs.
+func RestoreNode(id NodeID, q QSet, ch chan<- *Msg, p Persister, opts ...NodeOption) (*Node, error) {
+	states, ext, err := p.Load()
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, WithPersister(p))
+	n := NewNode(id, q, ch, opts...)
+	for slotID, topic := range ext {
+		err = n.ext.Put(slotID, topic)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, st := range states {
+		if _, ok := ext[st.ID]; ok {
+			continue
+		}
+		n.pending[st.ID] = restoreSlot(n, st)
+	}
+	if len(n.pending) > 0 {
+		n.scheduleRebroadcast()
+	}
+	return n, nil
+}
+
+// FilePersister is a Persister that stores each slot's state, and
+// each externalized value, in its own file beneath a directory. Files
+// are replaced atomically, so a crash leaves either the old or the
+// new state but never a mix.
+//
+// Values are stored with encoding/gob, so the concrete type(s) used
+// for Value must be registered with gob.Register.
+type FilePersister struct {
+	Dir string
+}
+
+const (
+	slotFileSuffix = ".slot"
+	extFileSuffix  = ".ext"
+)
+
+func init() {
+	gob.Register(&NomTopic{})
+	gob.Register(&NomPrepTopic{})
+	gob.Register(&PrepTopic{})
+	gob.Register(&CommitTopic{})
+	gob.Register(&ExtTopic{})
+}
+
+// NewFilePersister produces a FilePersister storing its files in dir,
+// creating the directory if necessary.
+func NewFilePersister(dir string) (*FilePersister, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return &FilePersister{Dir: dir}, nil
+}
+
+// SaveSlot implements Persister.SaveSlot.
+func (p *FilePersister) SaveSlot(st *SlotState) error {
+	return writeGobFile(p.Dir, p.filename(st.ID, slotFileSuffix), st)
+}
+
+// SaveExt implements Persister.SaveExt.
+func (p *FilePersister) SaveExt(slotID SlotID, topic *ExtTopic) error {
+	err := writeGobFile(p.Dir, extFilename(slotID), topic)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filepath.Join(p.Dir, p.filename(slotID, slotFileSuffix)))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Load implements Persister.Load.
+func (p *FilePersister) Load() ([]*SlotState, map[SlotID]*ExtTopic, error) {
+	infos, err := ioutil.ReadDir(p.Dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	var (
+		states []*SlotState
+		ext    = make(map[SlotID]*ExtTopic)
+	)
+	for _, info := range infos {
+		name := info.Name()
+		switch filepath.Ext(name) {
+		case slotFileSuffix:
+			var st SlotState
+			err = readGobFile(p.Dir, name, &st)
+			if err != nil {
+				return nil, nil, err
+			}
+			states = append(states, &st)
+
+		case extFileSuffix:
+			slotID, err := strconv.Atoi(strings.TrimSuffix(name, extFileSuffix))
+			if err != nil {
+				return nil, nil, fmt.Errorf("parsing slot ID in %s: %w", name, err)
+			}
+			var topic ExtTopic
+			err = readGobFile(p.Dir, name, &topic)
+			if err != nil {
+				return nil, nil, err
+			}
+			ext[SlotID(slotID)] = &topic
+		}
+	}
+	return states, ext, nil
+}
+
+func (p *FilePersister) filename(slotID SlotID, suffix string) string {
+	return strconv.Itoa(int(slotID)) + suffix
+}
+
+func readGobFile(dir, name string, v interface{}) error {
+	b, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return err
+	}
+	err = gob.NewDecoder(bytes.NewReader(b)).Decode(v)
+	if err != nil {
+		return fmt.Errorf("decoding %s: %w", name, err)
+	}
+	return nil
+}
+
+// Writes v to a temporary file in dir,
+// syncs it,
+// and renames it into place,
+// so that a crash leaves either the old or the new file but never a mix.
+func writeGobFile(dir, name string, v interface{}) error {
+	buf := new(bytes.Buffer)
+	err := gob.NewEncoder(buf).Encode(v)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, "tmp")
+	if err != nil {
+		return err
+	}
+	tmpname := f.Name()
+	defer os.Remove(tmpname) // no-op after a successful rename
+
+	_, err = f.Write(buf.Bytes())
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Sync()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpname, filepath.Join(dir, name))
+	if err != nil {
+		return err
+	}
+
+	// Make the rename durable.
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
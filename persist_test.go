@@ -0,0 +1,198 @@
+package scp
+
+import (
+	"encoding/gob"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func init() {
+	gob.Register(valtype(0))
+}
+
+func TestRestoreNode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scp-persist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p, err := NewFilePersister(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan *Msg, 10)
+	q := QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("y")}}}
+	n := NewNode("x", q, ch, WithPersister(p))
+
+	// Node y, the only member of x's slice, accepts <1,7> as prepared.
+	// Node x follows it into the balloting phase.
+	yQ := QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("x")}}}
+	err = handleNow(n, NewMsg("y", 1, yQ, &PrepTopic{B: Ballot{1, valtype(7)}, P: Ballot{1, valtype(7)}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := <-ch
+	if _, ok := sent.T.(*PrepTopic); !ok {
+		t.Fatalf("got %s, want a PREP message", sent)
+	}
+
+	n2, err := RestoreNode("x", q, ch, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s1, s2 := n.pending[1], n2.pending[1]
+	if s2 == nil {
+		t.Fatal("slot 1 not restored")
+	}
+	if s2.Ph != s1.Ph {
+		t.Errorf("got phase %d, want %d", s2.Ph, s1.Ph)
+	}
+	for _, pair := range [][2]Ballot{{s2.B, s1.B}, {s2.P, s1.P}, {s2.PP, s1.PP}, {s2.C, s1.C}, {s2.H, s1.H}} {
+		if pair[0].Less(pair[1]) || pair[1].Less(pair[0]) {
+			t.Errorf("got ballot %s, want %s", pair[0], pair[1])
+		}
+	}
+	if !s2.T.Equal(s1.T) {
+		t.Errorf("got T %s, want %s", s2.T, s1.T)
+	}
+	if !reflect.DeepEqual(s2.maxPriPeers, s1.maxPriPeers) {
+		t.Errorf("got maxPriPeers %v, want %v", s2.maxPriPeers, s1.maxPriPeers)
+	}
+	if !reflect.DeepEqual(s2.M["y"].T, s1.M["y"].T) {
+		t.Errorf("got message from y %s, want %s", s2.M["y"], s1.M["y"])
+	}
+	if !reflect.DeepEqual(s2.sent.T, s1.sent.T) {
+		t.Errorf("got sent %s, want %s", s2.sent, s1.sent)
+	}
+
+	// Now y externalizes and x follows.
+	err = handleNow(n2, NewMsg("y", 1, yQ, &ExtTopic{C: Ballot{1, valtype(7)}, HN: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent = <-ch
+	if _, ok := sent.T.(*ExtTopic); !ok {
+		t.Fatalf("got %s, want an EXT message", sent)
+	}
+
+	n3, err := RestoreNode("x", q, ch, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(n3.pending) != 0 {
+		t.Errorf("got %d pending slot(s), want 0", len(n3.pending))
+	}
+	topic, err := n3.ext.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topic == nil {
+		t.Fatal("slot 1 externalized value not restored")
+	}
+	if !ValueEqual(topic.C.X, valtype(7)) {
+		t.Errorf("got externalized value %s, want 7", topic.C.X)
+	}
+}
+
+// The deferred-update and ballot-counter timers, which aren't saved,
+// are re-armed for a restored slot.
+func TestRestoreTimers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scp-persist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p, err := NewFilePersister(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := NewFakeClock(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC))
+	ch := make(chan *Msg, 10)
+	q := QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("y")}}}
+	n := NewNode("x", q, ch, WithPersister(p), WithClock(clock))
+
+	// Node y is far ahead, at a ballot counter x may not reach yet.
+	yQ := QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("x")}}}
+	err = handleNow(n, NewMsg("y", 1, yQ, &PrepTopic{B: Ballot{2000, valtype(7)}, P: Ballot{1, valtype(7)}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s1 := n.pending[1]
+	if s1.Upd == nil || s1.bnTimer == nil {
+		t.Fatalf("got Upd %v and bnTimer %v, want both armed", s1.Upd, s1.bnTimer)
+	}
+
+	n2, err := RestoreNode("x", q, ch, p, WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2 := n2.pending[1]
+	if s2.Upd == nil {
+		t.Error("deferred-update timer not restored")
+	}
+	if s2.bnTimer == nil {
+		t.Error("ballot-counter timer not restored")
+	}
+}
+
+type failingPersister struct {
+	Persister
+	fail bool
+}
+
+func (p *failingPersister) SaveSlot(st *SlotState) error {
+	if p.fail {
+		return errors.New("disk full")
+	}
+	return p.Persister.SaveSlot(st)
+}
+
+// A message whose state couldn't be saved is not sent, and is
+// produced again afterwards.
+func TestSaveSlotFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scp-persist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fp, err := NewFilePersister(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &failingPersister{Persister: fp, fail: true}
+
+	ch := make(chan *Msg, 10)
+	q := QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("y")}}}
+	n := NewNode("x", q, ch, WithPersister(p))
+
+	yQ := QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("x")}}}
+	if err = handleNow(n, NewMsg("y", 1, yQ, &PrepTopic{B: Ballot{1, valtype(7)}, P: Ballot{1, valtype(7)}})); err == nil {
+		t.Fatal("got no error from failing persister")
+	}
+	if len(ch) != 0 {
+		t.Fatalf("got %d message(s) sent despite failing persister, want 0", len(ch))
+	}
+
+	// A message from a stranger changes nothing, but gives x another
+	// chance to send what it couldn't.
+	p.fail = false
+	zQ := QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("y")}}}
+	if err = handleNow(n, NewMsg("z", 1, zQ, &NomTopic{X: ValueSet{valtype(8)}})); err != nil {
+		t.Fatal(err)
+	}
+	if len(ch) != 1 {
+		t.Fatalf("got %d message(s) sent, want 1", len(ch))
+	}
+	if sent := <-ch; !reflect.DeepEqual(sent.T, n.pending[1].sent.T) {
+		t.Errorf("sent %s, but recorded %s", sent, n.pending[1].sent)
+	}
+}
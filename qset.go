@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"math/big"
+	"sort"
 )
 
 type (
@@ -27,87 +28,72 @@ type (
 	}
 )
 
-// Checks that at least one node in each quorum slice satisfies pred
-// (excluding the slot's node).
+// Weight returns the exact fraction of q's quorum slices in which id
+// appears. A node that appears in several inner QSets is counted
+// correctly (though such a QSet is not valid; see Validate).
 //
-// Works by finding len(q.M)-q.T+1 members for which pred is true
-func (q QSet) findBlockingSet(msgs map[NodeID]*Msg, pred predicate) (NodeIDSet, predicate) {
-	return findBlockingSetHelper(len(q.M)-q.T+1, q.M, msgs, pred, nil)
+// This is the weight as defined in the SCP paper. It differs from
+// stellar-core's, which multiplies threshold fractions down through
+// the inner QSets, whenever inner QSets have differing numbers of
+// slices. For example, in 2 of {a, b, 1 of {c, d}} the slices are
+// {a b}, {a c}, {a d}, {b c}, {b d}, so c has weight 2/5 here, but
+// 2/3 * 1/2 = 1/3 in stellar-core. Nodes therefore choose different
+// nomination neighbors than stellar-core would for the same QSet.
+func (q QSet) Weight(id NodeID) *big.Rat {
+	total, containing := q.countSlices(id)
+	if total.Sign() == 0 {
+		return new(big.Rat)
+	}
+	return new(big.Rat).SetFrac(containing, total)
 }
 
-func findBlockingSetHelper(needed int, members []QSetMember, msgs map[NodeID]*Msg, pred predicate, sofar NodeIDSet) (NodeIDSet, predicate) {
-	if needed == 0 {
-		return sofar, pred
-	}
-	if needed > len(members) {
-		return nil, pred
-	}
-	m0 := members[0]
-	switch {
-	case m0.N != nil:
-		if msg, ok := msgs[*m0.N]; ok {
-			if nextPred := pred.test(msg); nextPred != nil {
-				return findBlockingSetHelper(needed-1, members[1:], msgs, nextPred, sofar.Add(*m0.N))
+// Returns the number of slices of q
+// and the number of those that contain id.
+// A slice contains id unless every member chosen for it
+// contributes a sub-slice without id.
+func (q QSet) countSlices(id NodeID) (total, containing *big.Int) {
+	var all, without []*big.Int
+	for _, m := range q.M {
+		switch {
+		case m.N != nil:
+			all = append(all, big.NewInt(1))
+			if *m.N == id {
+				without = append(without, big.NewInt(0))
+			} else {
+				without = append(without, big.NewInt(1))
 			}
-		}
 
-	case m0.Q != nil:
-		sofar2, pred2 := findBlockingSetHelper(len(m0.Q.M)-m0.Q.T+1, m0.Q.M, msgs, pred, sofar)
-		if len(sofar2) > 0 {
-			return findBlockingSetHelper(needed-1, members[1:], msgs, pred2, sofar2)
+		case m.Q != nil:
+			t, c := m.Q.countSlices(id)
+			all = append(all, t)
+			without = append(without, new(big.Int).Sub(t, c))
 		}
 	}
-	return findBlockingSetHelper(needed, members[1:], msgs, pred, sofar)
+	total = countChoices(q.T, all)
+	containing = new(big.Int).Sub(total, countChoices(q.T, without))
+	return total, containing
 }
 
-// Finds a quorum in which every node satisfies the given
-// predicate. The slot's node itself is presumed to satisfy the
-// predicate.
-func (q QSet) findQuorum(nodeID NodeID, m map[NodeID]*Msg, pred predicate) (NodeIDSet, predicate) {
-	return findQuorumHelper(q.T, q.M, m, pred, NodeIDSet{nodeID})
-}
-
-func findQuorumHelper(threshold int, members []QSetMember, msgs map[NodeID]*Msg, pred predicate, sofar NodeIDSet) (NodeIDSet, predicate) {
-	if threshold == 0 {
-		return sofar, pred
+// Returns the number of ways to choose k of a list of members,
+// where member i itself can be chosen in counts[i] ways.
+// (This is the elementary symmetric polynomial of degree k.)
+func countChoices(k int, counts []*big.Int) *big.Int {
+	if k < 0 {
+		return new(big.Int)
 	}
-	if threshold > len(members) {
-		return nil, pred
+	// e[j] is the number of ways to choose j of the members seen so far.
+	e := make([]*big.Int, k+1)
+	for j := range e {
+		e[j] = new(big.Int)
 	}
-	m0 := members[0]
-	switch {
-	case m0.N != nil:
-		if sofar.Contains(*m0.N) {
-			return findQuorumHelper(threshold-1, members[1:], msgs, pred, sofar)
-		}
-		if msg, ok := msgs[*m0.N]; ok {
-			if nextPred := pred.test(msg); nextPred != nil {
-				sofar2, pred2 := findQuorumHelper(msg.Q.T, msg.Q.M, msgs, nextPred, sofar.Add(*m0.N))
-				if len(sofar2) > 0 {
-					return findQuorumHelper(threshold-1, members[1:], msgs, pred2, sofar2)
-				}
-			}
+	e[0].SetInt64(1)
+	tmp := new(big.Int)
+	for _, c := range counts {
+		for j := k; j >= 1; j-- {
+			e[j].Add(e[j], tmp.Mul(e[j-1], c))
 		}
-
-	case m0.Q != nil:
-		sofar2, pred2 := findQuorumHelper(m0.Q.T, m0.Q.M, msgs, pred, sofar)
-		if len(sofar2) > 0 {
-			return findQuorumHelper(threshold-1, members[1:], msgs, pred2, sofar2)
-		}
-	}
-	return findQuorumHelper(threshold, members[1:], msgs, pred, sofar)
-}
-
-// Function weight returns the fraction of q's quorum slices in which id appears.
-// Return value is the fraction and
-// (as an optimization)
-// a bool indicating whether it's exactly 1.
-func (q QSet) weight(id NodeID) (float64, bool) {
-	num, denom := q.NodeFrac(id)
-	if num == denom {
-		return 1.0, true
 	}
-	return float64(num) / float64(denom), false
+	return e[k]
 }
 
 // Slices calls f once for each slice represented by q.
@@ -165,37 +151,28 @@ func (q QSet) Nodes() NodeIDSet {
 	return result
 }
 
+// NumSlices tells how many quorum slices q represents.
 func (q QSet) NumSlices() *big.Int {
-	result := new(big.Int)
-	result.Binomial(int64(len(q.M)), int64(q.T))
-	for _, m := range q.M {
-		if m.Q != nil {
-			result.Mul(result, m.Q.NumSlices())
-		}
-	}
-	return result
+	total, _ := q.countSlices("")
+	return total
 }
 
-// NodeFrac gives the fraction of slices in q containing the given node.
-// It assumes that id appears in at most one QSet
-// (either the top level one or a single reachable nested one)
-// and then only once in that QSet.
+// NodeFrac gives the fraction of slices in q containing the given
+// node. When the exact fraction doesn't fit in an int, the result is
+// the closest fraction not exceeding it with a denominator of the
+// largest int.
+//
+// Deprecated: use Weight, which is always exact.
 func (q QSet) NodeFrac(id NodeID) (num, denom int) {
-	for _, m := range q.M {
-		switch {
-		case m.N != nil:
-			if *m.N == id {
-				return q.T, len(q.M)
-			}
-
-		case m.Q != nil:
-			num2, denom2 := m.Q.NodeFrac(id)
-			if num2 > 0 {
-				return q.T * num2, len(q.M) * denom2
-			}
-		}
+	w := q.Weight(id)
+	maxInt := big.NewInt(int64(^uint(0) >> 1))
+	if w.Denom().Cmp(maxInt) <= 0 {
+		return int(w.Num().Int64()), int(w.Denom().Int64())
 	}
-	return 0, 1
+	// The weight is at most 1, so only the denominator can overflow.
+	n := new(big.Int).Mul(w.Num(), maxInt)
+	n.Quo(n, w.Denom())
+	return int(n.Int64()), int(maxInt.Int64())
 }
 
 func (m QSetMember) String() string {
@@ -217,3 +194,154 @@ func (m QSetMember) String() string {
 	}
 	return ""
 }
+
+// Validate checks that q is well formed: every threshold is between 1
+// and the number of members, every member has exactly one of N and Q,
+// no node appears more than once anywhere in q, and inner QSets are
+// nested no more than maxQSetDepth levels deep.
+func (q QSet) Validate() error {
+	return q.validate("top level", 0, make(map[NodeID]bool))
+}
+
+func (q QSet) validate(where string, depth int, seen map[NodeID]bool) error {
+	if depth > maxQSetDepth {
+		return fmt.Errorf("%s: nested more than %d levels deep", where, maxQSetDepth)
+	}
+	if q.T < 1 || q.T > len(q.M) {
+		return fmt.Errorf("%s: threshold %d out of range for %d member(s)", where, q.T, len(q.M))
+	}
+	for i, m := range q.M {
+		switch {
+		case m.N != nil && m.Q != nil:
+			return fmt.Errorf("%s: member %d has both a node ID and an inner QSet", where, i)
+
+		case m.N != nil:
+			if seen[*m.N] {
+				return fmt.Errorf("%s: duplicate node %s", where, *m.N)
+			}
+			seen[*m.N] = true
+
+		case m.Q != nil:
+			err := m.Q.validate(fmt.Sprintf("%s, member %d", where, i), depth+1, seen)
+			if err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("%s: member %d is empty", where, i)
+		}
+	}
+	return nil
+}
+
+// Normalize produces a canonical form of q, in which inner QSets are
+// normalized, inner QSets with a single member are replaced by that
+// member, duplicate members are removed (reducing the threshold if
+// necessary), and members are sorted: node IDs first, in order, then
+// inner QSets. A node appearing more than once, at any level, is kept
+// only where it appears first in that order.
+//
+// Normalizing does not change the slices represented by a valid
+// QSet, apart from the order of members. (Removing a duplicate member
+// does change the slices, but a QSet with duplicates is invalid.)
+func (q QSet) Normalize() QSet {
+	return q.sorted().dedupe(make(map[NodeID]bool)).sorted()
+}
+
+// Sorts q's members, recursively, removing equal members and
+// collapsing singleton inner QSets.
+func (q QSet) sorted() QSet {
+	var members []QSetMember
+	for _, m := range q.M {
+		switch {
+		case m.N != nil:
+			id := *m.N
+			members = append(members, QSetMember{N: &id})
+
+		case m.Q != nil:
+			inner := m.Q.sorted()
+			if len(inner.M) == 1 && inner.T == 1 {
+				members = append(members, inner.M[0])
+			} else {
+				members = append(members, QSetMember{Q: &inner})
+			}
+		}
+	}
+	sort.SliceStable(members, func(i, j int) bool {
+		return members[i].less(members[j])
+	})
+
+	result := QSet{T: q.T}
+	for i, m := range members {
+		if i > 0 && !members[i-1].less(m) {
+			// Equal to the previous member.
+			continue
+		}
+		result.M = append(result.M, m)
+	}
+	if result.T > len(result.M) {
+		result.T = len(result.M)
+	}
+	return result
+}
+
+// Removes from q, recursively, the nodes in seen, adding the rest to
+// seen as they are encountered. Inner QSets left empty are removed,
+// and thresholds are reduced as necessary.
+func (q QSet) dedupe(seen map[NodeID]bool) QSet {
+	result := QSet{T: q.T}
+	for _, m := range q.M {
+		switch {
+		case m.N != nil:
+			if seen[*m.N] {
+				continue
+			}
+			seen[*m.N] = true
+			result.M = append(result.M, m)
+
+		case m.Q != nil:
+			inner := m.Q.dedupe(seen)
+			if len(inner.M) > 0 {
+				result.M = append(result.M, QSetMember{Q: &inner})
+			}
+		}
+	}
+	if result.T > len(result.M) {
+		result.T = len(result.M)
+	}
+	return result
+}
+
+// Orders QSets by threshold, then by members.
+func (q QSet) less(other QSet) bool {
+	if q.T != other.T {
+		return q.T < other.T
+	}
+	for i := 0; i < len(q.M) && i < len(other.M); i++ {
+		if q.M[i].less(other.M[i]) {
+			return true
+		}
+		if other.M[i].less(q.M[i]) {
+			return false
+		}
+	}
+	return len(q.M) < len(other.M)
+}
+
+// Orders members: node IDs first, in order, then inner QSets.
+func (m QSetMember) less(other QSetMember) bool {
+	switch {
+	case m.N != nil && other.N != nil:
+		return *m.N < *other.N
+
+	case m.N != nil:
+		return true
+
+	case other.N != nil:
+		return false
+
+	case m.Q != nil && other.Q != nil:
+		return m.Q.less(*other.Q)
+	}
+	return false
+}
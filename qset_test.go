@@ -0,0 +1,153 @@
+package scp
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestQSetValidate(t *testing.T) {
+	a, b := nodeIDPtr("a"), nodeIDPtr("b")
+	nested := QSet{T: 1, M: []QSetMember{{N: a}}}
+	for i := 0; i < maxQSetDepth+1; i++ {
+		inner := nested
+		nested = QSet{T: 1, M: []QSetMember{{Q: &inner}}}
+	}
+
+	cases := []struct {
+		q    QSet
+		want string // substring of the error, or "" for none
+	}{
+		{
+			q: QSet{T: 1, M: []QSetMember{{N: a}}},
+		},
+		{
+			q: QSet{T: 2, M: []QSetMember{{N: a}, {Q: &QSet{T: 1, M: []QSetMember{{N: b}}}}}},
+		},
+		{
+			q:    QSet{},
+			want: "threshold 0 out of range",
+		},
+		{
+			q:    QSet{T: 2, M: []QSetMember{{N: a}}},
+			want: "threshold 2 out of range",
+		},
+		{
+			q:    QSet{T: 1, M: []QSetMember{{N: a}, {N: a}}},
+			want: "duplicate node a",
+		},
+		{
+			q:    QSet{T: 1, M: []QSetMember{{N: a}, {Q: &QSet{T: 1, M: []QSetMember{{N: a}}}}}},
+			want: "member 1: duplicate node a",
+		},
+		{
+			q:    QSet{T: 1, M: []QSetMember{{N: a, Q: &QSet{T: 1, M: []QSetMember{{N: b}}}}}},
+			want: "both",
+		},
+		{
+			q:    QSet{T: 1, M: []QSetMember{{}}},
+			want: "empty",
+		},
+		{
+			q:    nested,
+			want: "levels deep",
+		},
+	}
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%02d", i+1), func(t *testing.T) {
+			err := tc.q.Validate()
+			if tc.want == "" {
+				if err != nil {
+					t.Errorf("got error %s", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("got no error, want %q", tc.want)
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("got error %q, want %q", err, tc.want)
+			}
+
+			// Messages with this QSet are invalid too.
+			msg := NewMsg("x", 1, tc.q, &NomTopic{})
+			if msg.valid() == nil {
+				t.Error("message is valid")
+			}
+		})
+	}
+}
+
+func TestQSetNormalize(t *testing.T) {
+	a, b, c := nodeIDPtr("a"), nodeIDPtr("b"), nodeIDPtr("c")
+	cases := []struct {
+		q, want QSet
+	}{
+		{
+			q:    QSet{T: 1, M: []QSetMember{{N: b}, {N: a}}},
+			want: QSet{T: 1, M: []QSetMember{{N: a}, {N: b}}},
+		},
+		{
+			// Singleton inner sets collapse.
+			q:    QSet{T: 2, M: []QSetMember{{Q: &QSet{T: 1, M: []QSetMember{{N: c}}}}, {N: b}}},
+			want: QSet{T: 2, M: []QSetMember{{N: b}, {N: c}}},
+		},
+		{
+			// Duplicates are removed.
+			q:    QSet{T: 3, M: []QSetMember{{N: a}, {N: b}, {N: a}}},
+			want: QSet{T: 2, M: []QSetMember{{N: a}, {N: b}}},
+		},
+		{
+			// Inner sets are sorted and deduplicated, and so are nodes
+			// appearing at different levels.
+			q: QSet{T: 2, M: []QSetMember{
+				{Q: &QSet{T: 1, M: []QSetMember{{N: c}, {N: b}}}},
+				{Q: &QSet{T: 1, M: []QSetMember{{N: a}, {N: b}}}},
+				{Q: &QSet{T: 1, M: []QSetMember{{N: b}, {N: c}}}},
+				{N: c},
+			}},
+			want: QSet{T: 2, M: []QSetMember{
+				{N: c},
+				{Q: &QSet{T: 1, M: []QSetMember{{N: a}, {N: b}}}},
+			}},
+		},
+		{
+			// A node in an inner set duplicating one at the top level.
+			q: QSet{T: 2, M: []QSetMember{
+				{Q: &QSet{T: 2, M: []QSetMember{{N: c}, {N: a}, {N: b}}}},
+				{N: a},
+			}},
+			want: QSet{T: 2, M: []QSetMember{
+				{N: a},
+				{Q: &QSet{T: 2, M: []QSetMember{{N: b}, {N: c}}}},
+			}},
+		},
+		{
+			// Duplicates in different inner sets, one of which is left
+			// with a single member.
+			q: QSet{T: 2, M: []QSetMember{
+				{Q: &QSet{T: 2, M: []QSetMember{{N: b}, {N: c}}}},
+				{Q: &QSet{T: 2, M: []QSetMember{{N: a}, {N: b}}}},
+			}},
+			want: QSet{T: 2, M: []QSetMember{
+				{N: c},
+				{Q: &QSet{T: 2, M: []QSetMember{{N: a}, {N: b}}}},
+			}},
+		},
+	}
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%02d", i+1), func(t *testing.T) {
+			got := tc.q.Normalize()
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+			if again := got.Normalize(); !reflect.DeepEqual(again, got) {
+				t.Errorf("normalizing again produced %v", again)
+			}
+			if err := got.Validate(); err != nil {
+				t.Errorf("result is invalid: %s", err)
+			}
+		})
+	}
+}
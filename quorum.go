@@ -1,5 +1,10 @@
 package scp
 
+import (
+	"fmt"
+	"strings"
+)
+
 // This file contains functions for finding "blocking sets" and
 // "quorums" that satisfy a given predicate.
 //
@@ -29,16 +34,23 @@ package scp
 // Checks that at least one node in each quorum slice satisfies pred
 // (excluding the slot's node).
 func (s *Slot) findBlockingSet(pred predicate) NodeIDSet {
-	res, _ := s.V.Q.findBlockingSet(s.M, pred)
-	return res
+	return s.view().findBlockingSet(pred)
 }
 
 // Finds a quorum in which every node satisfies the given
 // predicate. The slot's node itself is presumed to satisfy the
 // predicate.
 func (s *Slot) findQuorum(pred predicate) NodeIDSet {
-	res, _ := s.V.Q.findQuorum(s.V.ID, s.M, pred)
-	return res
+	return s.view().findQuorum(pred)
+}
+
+// Returns the indexed view of s.M used for searching (see qview),
+// creating it if necessary.
+func (s *Slot) view() *qview {
+	if s.qv == nil {
+		s.qv = newQView(newNodeIndex(), s.V.ID, s.V.Q, s.M)
+	}
+	return s.qv
 }
 
 // Tells whether a statement can be accepted, either because a
@@ -52,13 +64,20 @@ func (s *Slot) accept(f func(bool) predicate) NodeIDSet {
 	//    (since it is its own blocking set and,
 	//    more intuitively,
 	//    node N can accept X if N already accepts X).
-	acceptsPred := f(false)
-	if s.sent != nil && acceptsPred.test(s.sent) != nil {
-		return NodeIDSet{s.V.ID}
+	if s.sent != nil {
+		if pred := f(false).test(s.sent); pred != nil {
+			finish(pred)
+			return NodeIDSet{s.V.ID}
+		}
 	}
+	return s.acceptFromPeers(f)
+}
 
+// Like accept, but without considering whether s's node already
+// accepts the statement.
+func (s *Slot) acceptFromPeers(f func(bool) predicate) NodeIDSet {
 	// 2. Look for a blocking set apart from s.V that accepts.
-	nodeIDs := s.findBlockingSet(acceptsPred)
+	nodeIDs := s.findBlockingSet(f(false))
 	if len(nodeIDs) > 0 {
 		return nodeIDs
 	}
@@ -72,6 +91,29 @@ func (s *Slot) accept(f func(bool) predicate) NodeIDSet {
 	return s.findQuorum(votesOrAcceptsPred)
 }
 
+// Finds the maximal subset of bs whose members satisfy search (which
+// should look for a blocking set or quorum). A search that narrows a
+// set of ballots as it goes, like one using ballotSetPred, reports
+// only the ballots satisfied by the first quorum it finds. Higher
+// ones, satisfied by a different quorum, are missed. This instead
+// tries each ballot in turn, highest first. A ballot satisfied by
+// some quorum implies the lower ballots with the same value, so
+// those are included without searching.
+func maxBallotSet(bs BallotSet, search func(Ballot) bool) BallotSet {
+	var (
+		result BallotSet
+		found  ValueSet
+	)
+	for i := len(bs) - 1; i >= 0; i-- {
+		b := bs[i]
+		if found.Contains(b.X) || search(b) {
+			result = result.Add(b)
+			found = found.Add(b.X)
+		}
+	}
+	return result
+}
+
 // Abstract predicate. Concrete types below.
 type predicate interface {
 	// Tests whether a node's latest message satisfies this predicate.
@@ -80,9 +122,91 @@ type predicate interface {
 	// or an updated copy of the predicate for use in a subsequent call to test.
 	// The original predicate should not change, because when findQuorum needs to backtrack,
 	// it also unwinds to earlier values of the predicate.
+	// The result must depend only on the predicate and the message,
+	// since it is memoized (see qview),
+	// and the predicate's dynamic type must be comparable
+	// (apart from fpred, which qview handles specially).
 	test(*Msg) predicate
 }
 
+// A predicate may have a key identifying what it tests, so that
+// qview can share memoized results among predicates with equal keys,
+// even ones made separately for different searches. Two predicates
+// with the same key must give the same results on every message.
+type keyedPredicate interface {
+	predicate
+
+	// Returns the predicate's key, or false if it has none.
+	key() (predKey, bool)
+}
+
+// Identifies a predicate: kind names the statement it tests, arg
+// holds the parameters fixed when it was made (such as a ballot), and
+// state holds whatever it has narrowed as a search proceeds.
+type predKey struct {
+	kind, arg, state string
+}
+
+// Produces a key for a predicate of the given kind and arg (the
+// zero-valued predKey and false if kind is empty), computing it at
+// most once, in *cache.
+func makePredKey(cache **predKey, kind, arg string, state func() string) (predKey, bool) {
+	if kind == "" {
+		return predKey{}, false
+	}
+	if *cache == nil {
+		*cache = &predKey{kind: kind, arg: arg, state: state()}
+	}
+	return **cache, true
+}
+
+// The unambiguous string forms of values and ballots used in
+// predicate keys.
+
+func valueKey(v Value) string {
+	if v == nil {
+		return "-"
+	}
+	b := v.Bytes()
+	return fmt.Sprintf("%d:%s", len(b), b)
+}
+
+func ballotKey(b Ballot) string {
+	return fmt.Sprintf("%d/%s", b.N, valueKey(b.X))
+}
+
+// A predicate that narrows some state as it traverses nodes may
+// report the state it ends up with at the end of a successful search,
+// i.e. the state common to all the nodes found.
+type finalPredicate interface {
+	predicate
+
+	// Records the predicate's state wherever the caller asked for it.
+	finish()
+}
+
+func finish(pred predicate) {
+	if fp, ok := pred.(finalPredicate); ok {
+		fp.finish()
+	}
+}
+
+// A predicate may also be able to tell from a node's latest message
+// that the node has confirmed the statement being tested. That means
+// the node knows of a quorum of its own that accepts the statement,
+// so a quorum search (see findQuorumHelper) can include the node
+// without searching its slices. ("Once "v" enters the confirmed
+// state, it may issue a _confirm_ "a" message to help other nodes
+// confirm "a" more efficiently by pruning their quorum search at
+// "v".")
+type confirmPredicate interface {
+	predicate
+
+	// Like test, but tells whether the node's latest message confirms
+	// the statement.
+	confirmed(*Msg) predicate
+}
+
 // This is a simple function predicate. It does not change from one
 // call to the next.
 type fpred func(*Msg) bool
@@ -94,12 +218,49 @@ func (f fpred) test(msg *Msg) predicate {
 	return nil
 }
 
+// An fpred in a form that can be a map key (see qview.start), and
+// that can have a key (see keyedPred).
+type boolPred struct {
+	f         fpred
+	kind, arg string
+	k         *predKey
+}
+
+// Produces a predicate from f with the given kind and arg (see
+// predKey), which must determine what f tests.
+func keyedPred(kind, arg string, f fpred) *boolPred {
+	return &boolPred{f: f, kind: kind, arg: arg}
+}
+
+func (p *boolPred) key() (predKey, bool) {
+	return makePredKey(&p.k, p.kind, p.arg, func() string { return "" })
+}
+
+func (p *boolPred) test(msg *Msg) predicate {
+	if p.f(msg) {
+		return p
+	}
+	return nil
+}
+
 // This is a predicate that can narrow a set of values as it traverses
 // nodes.
 type valueSetPred struct {
 	vals      ValueSet
 	finalVals *ValueSet
 	testfn    func(*Msg, ValueSet) ValueSet
+	kind, arg string // see predKey
+	k         *predKey
+}
+
+func (p *valueSetPred) key() (predKey, bool) {
+	return makePredKey(&p.k, p.kind, p.arg, func() string {
+		var b strings.Builder
+		for _, v := range p.vals {
+			b.WriteString(valueKey(v))
+		}
+		return b.String()
+	})
 }
 
 func (p *valueSetPred) test(msg *Msg) predicate {
@@ -110,70 +271,141 @@ func (p *valueSetPred) test(msg *Msg) predicate {
 	if len(nextVals) == 0 {
 		return nil
 	}
-	if p.finalVals != nil {
-		*p.finalVals = nextVals
+	if len(nextVals) == len(p.vals) {
+		return p
 	}
 	return &valueSetPred{
 		vals:      nextVals,
 		finalVals: p.finalVals,
 		testfn:    p.testfn,
+		kind:      p.kind,
+		arg:       p.arg,
+	}
+}
+
+func (p *valueSetPred) finish() {
+	if p.finalVals != nil {
+		*p.finalVals = p.vals
 	}
 }
 
 // This is a predicate that can narrow a set of ballots as it traverses
-// nodes.
+// nodes. If confirmfn is set, it implements confirmPredicate.
 type ballotSetPred struct {
 	ballots      BallotSet
 	finalBallots *BallotSet
 	testfn       func(*Msg, BallotSet) BallotSet
+	confirmfn    func(*Msg, BallotSet) BallotSet
+	kind, arg    string // see predKey
+	k            *predKey
+}
+
+func (p *ballotSetPred) key() (predKey, bool) {
+	return makePredKey(&p.k, p.kind, p.arg, func() string {
+		var b strings.Builder
+		for _, ballot := range p.ballots {
+			b.WriteString(ballotKey(ballot))
+			b.WriteByte(' ')
+		}
+		return b.String()
+	})
 }
 
 func (p *ballotSetPred) test(msg *Msg) predicate {
+	return p.next(p.testfn, msg)
+}
+
+func (p *ballotSetPred) confirmed(msg *Msg) predicate {
+	if p.confirmfn == nil {
+		return nil
+	}
+	return p.next(p.confirmfn, msg)
+}
+
+func (p *ballotSetPred) next(fn func(*Msg, BallotSet) BallotSet, msg *Msg) predicate {
 	if len(p.ballots) == 0 {
 		return nil
 	}
-	nextBallots := p.testfn(msg, p.ballots)
+	nextBallots := fn(msg, p.ballots)
 	if len(nextBallots) == 0 {
 		return nil
 	}
-	if p.finalBallots != nil {
-		*p.finalBallots = nextBallots
+	if len(nextBallots) == len(p.ballots) {
+		return p
 	}
 	return &ballotSetPred{
 		ballots:      nextBallots,
 		finalBallots: p.finalBallots,
 		testfn:       p.testfn,
+		confirmfn:    p.confirmfn,
+		kind:         p.kind,
+		arg:          p.arg,
+	}
+}
+
+func (p *ballotSetPred) finish() {
+	if p.finalBallots != nil {
+		*p.finalBallots = p.ballots
 	}
 }
 
 // This is a predicate that can narrow a set of min/max bounds as it
-// traverses nodes.
+// traverses nodes. If confirmfn is set, it implements
+// confirmPredicate.
 type minMaxPred struct {
 	min, max           int  // the current min/max bounds
-	finalMin, finalMax *int // each call to next updates the min/max bounds these point to
+	finalMin, finalMax *int // finish records the min/max bounds in these
 	testfn             func(msg *Msg, min, max int) (bool, int, int)
+	confirmfn          func(msg *Msg, min, max int) (bool, int, int)
+	kind, arg          string // see predKey
+	k                  *predKey
+}
+
+func (p *minMaxPred) key() (predKey, bool) {
+	return makePredKey(&p.k, p.kind, p.arg, func() string {
+		return fmt.Sprintf("%d-%d", p.min, p.max)
+	})
 }
 
 func (p *minMaxPred) test(msg *Msg) predicate {
+	return p.next(p.testfn, msg)
+}
+
+func (p *minMaxPred) confirmed(msg *Msg) predicate {
+	if p.confirmfn == nil {
+		return nil
+	}
+	return p.next(p.confirmfn, msg)
+}
+
+func (p *minMaxPred) next(fn func(msg *Msg, min, max int) (bool, int, int), msg *Msg) predicate {
 	if p.min > p.max {
 		return nil
 	}
-	res, min, max := p.testfn(msg, p.min, p.max)
+	res, min, max := fn(msg, p.min, p.max)
 	if !res {
 		return nil
 	}
-	nextMin, nextMax := min, max
+	if min == p.min && max == p.max {
+		return p
+	}
+	return &minMaxPred{
+		min:       min,
+		max:       max,
+		finalMin:  p.finalMin,
+		finalMax:  p.finalMax,
+		testfn:    p.testfn,
+		confirmfn: p.confirmfn,
+		kind:      p.kind,
+		arg:       p.arg,
+	}
+}
+
+func (p *minMaxPred) finish() {
 	if p.finalMin != nil {
-		*p.finalMin = nextMin
+		*p.finalMin = p.min
 	}
 	if p.finalMax != nil {
-		*p.finalMax = nextMax
-	}
-	return &minMaxPred{
-		min:      nextMin,
-		max:      nextMax,
-		finalMin: p.finalMin,
-		finalMax: p.finalMax,
-		testfn:   p.testfn,
+		*p.finalMax = p.max
 	}
 }
@@ -3,6 +3,7 @@ package scp
 import (
 	"bufio"
 	"fmt"
+	"math/rand"
 	"reflect"
 	"strings"
 	"testing"
@@ -40,7 +41,7 @@ func TestFindBlockingSet(t *testing.T) {
 		t.Run(fmt.Sprintf("%02d", i+1), func(t *testing.T) {
 			network := toNetwork(tc.network)
 			ch := make(chan *Msg)
-			node := NewNode("x", slicesToQSet(network["x"]), ch, nil)
+			node := NewNode("x", slicesToQSet(network["x"]), ch)
 			slot, _ := newSlot(1, node)
 			for _, vstr := range strings.Fields(tc.msgs) {
 				v := NodeID(vstr)
@@ -425,7 +426,7 @@ func TestFindQuorum(t *testing.T) {
 	}
 	for i, tc := range cases {
 		t.Run(fmt.Sprintf("%02d", i+1), func(t *testing.T) {
-			got, _ := tc.q.findQuorum(tc.id, tc.m, fpred(func(msg *Msg) bool {
+			got := newQView(newNodeIndex(), tc.id, tc.q, tc.m).findQuorum(fpred(func(msg *Msg) bool {
 				return msg.V[0] == tc.id[0]
 			}))
 			if !reflect.DeepEqual(got, tc.want) {
@@ -435,6 +436,395 @@ func TestFindQuorum(t *testing.T) {
 	}
 }
 
+func TestFindQuorumConfirm(t *testing.T) {
+	// Only a's message is known, so a quorum can be found only by
+	// pruning the search at a.
+	network := toNetwork("x(a) a(b) b(a)")
+	prep := func(hn int, x Value) *Msg {
+		return NewMsg("a", 1, slicesToQSet(network["a"]), &PrepTopic{
+			B:  Ballot{1, x},
+			P:  Ballot{1, x},
+			HN: hn,
+		})
+	}
+	cases := []struct {
+		msg     *Msg
+		ballots BallotSet
+		want    NodeIDSet
+	}{
+		{
+			msg:     prep(1, valtype(1)),
+			ballots: BallotSet{{1, valtype(1)}},
+			want:    NodeIDSet{"a", "x"},
+		},
+		{
+			// a accepts but doesn't confirm.
+			msg:     prep(0, valtype(1)),
+			ballots: BallotSet{{1, valtype(1)}},
+		},
+		{
+			// a confirms a different value.
+			msg:     prep(1, valtype(2)),
+			ballots: BallotSet{{1, valtype(1)}},
+		},
+		{
+			// a confirms a lower ballot.
+			msg:     prep(1, valtype(1)),
+			ballots: BallotSet{{2, valtype(1)}},
+		},
+		{
+			msg:     NewMsg("a", 1, slicesToQSet(network["a"]), &ExtTopic{C: Ballot{1, valtype(1)}, HN: 1}),
+			ballots: BallotSet{{5, valtype(1)}},
+			want:    NodeIDSet{"a", "x"},
+		},
+	}
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%02d", i+1), func(t *testing.T) {
+			q := slicesToQSet(network["x"])
+			m := map[NodeID]*Msg{"a": tc.msg}
+			var out BallotSet
+			got := newQView(newNodeIndex(), "x", q, m).findQuorum(confirmPreparedPred(tc.ballots, &out))
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+			if len(got) > 0 && !reflect.DeepEqual(out, tc.ballots) {
+				t.Errorf("got ballots %v, want %v", out, tc.ballots)
+			}
+		})
+	}
+}
+
+// Confirms a ballot prepared from the point of view of each node in
+// a network where every node accepts it and every other node (in
+// sorted order) confirms it. Reports the number of predicate
+// evaluations needed, with and without pruning the search at
+// confirming nodes.
+func BenchmarkFindQuorumConfirm(b *testing.B) {
+	for _, name := range []string{"3tiers", "stars"} {
+		network := loadNetwork(b, name+".toml")
+		var ids NodeIDSet
+		for id := range network {
+			ids = ids.Add(id)
+		}
+		ballot := Ballot{1, valtype(1)}
+		msgs := make(map[NodeID]*Msg)
+		for i, id := range ids {
+			topic := &PrepTopic{B: ballot, P: ballot}
+			if i%2 == 0 {
+				topic.HN = 1
+			}
+			msgs[id] = NewMsg(id, 1, network[id], topic)
+		}
+
+		for _, prune := range []bool{false, true} {
+			b.Run(fmt.Sprintf("%s/prune=%v", name, prune), func(b *testing.B) {
+				var evals, confirms int
+				pred := confirmPreparedPred(BallotSet{ballot}, nil)
+				testfn, confirmfn := pred.testfn, pred.confirmfn
+				pred.testfn = func(msg *Msg, ballots BallotSet) BallotSet {
+					evals++
+					return testfn(msg, ballots)
+				}
+				if prune {
+					pred.confirmfn = func(msg *Msg, ballots BallotSet) BallotSet {
+						confirms++
+						return confirmfn(msg, ballots)
+					}
+				} else {
+					pred.confirmfn = nil
+				}
+
+				x := newNodeIndex()
+				var views []*qview
+				for _, id := range ids {
+					others := make(map[NodeID]*Msg, len(msgs))
+					for k, v := range msgs {
+						if k != id {
+							others[k] = v
+						}
+					}
+					views = append(views, newQView(x, id, network[id], others))
+				}
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					for _, v := range views {
+						v.findQuorum(pred)
+						v.clearMemo()
+					}
+				}
+				b.ReportMetric(float64(evals)/float64(b.N), "evals/op")
+				b.ReportMetric(float64(confirms)/float64(b.N), "confirms/op")
+			})
+		}
+	}
+}
+
+// A slot's node index holds the IDs it has seen, including strangers,
+// and goes away when the slot closes.
+func TestSlotViewScope(t *testing.T) {
+	ch := make(chan *Msg, 10)
+	q := QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("y")}}}
+	yQ := QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("x")}}}
+	n := NewNode("x", q, ch)
+
+	err := handleNow(n, NewMsg("stranger", 1, yQ, &NomTopic{X: ValueSet{valtype(7)}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := n.pending[1]
+	if s == nil || s.qv == nil {
+		t.Fatal("no view for slot 1")
+	}
+	if _, ok := s.qv.x.idx["stranger"]; !ok {
+		t.Error("stranger not in slot 1's index")
+	}
+
+	err = handleNow(n, NewMsg("y", 1, yQ, &ExtTopic{C: Ballot{1, valtype(7)}, HN: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := n.pending[1]; ok {
+		t.Fatal("slot 1 still pending")
+	}
+	if s.qv != nil {
+		t.Error("slot 1 kept its view after closing")
+	}
+}
+
+// Compares qview's searches with the reference implementation below
+// from the point of view of every node in a variety of networks.
+func TestQViewReference(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var networks []map[NodeID]QSet
+	for _, name := range []string{"3tiers.toml", "stars.toml", "fig3.toml"} {
+		networks = append(networks, loadNetwork(t, name))
+	}
+	for i := 0; i < 20; i++ {
+		networks = append(networks, randomNetwork(rng, 2+rng.Intn(8)))
+	}
+	for _, n := range []int{10, 30, 100} {
+		networks = append(networks, tieredNetwork(rng, n))
+	}
+
+	for i, network := range networks {
+		t.Run(fmt.Sprintf("%02d", i+1), func(t *testing.T) {
+			// Some nodes prepare a different value, and some have sent
+			// nothing.
+			var (
+				msgs    = make(map[NodeID]*Msg)
+				ballots = BallotSet{{1, valtype(1)}, {1, valtype(2)}}
+			)
+			for id, q := range network {
+				if rng.Intn(5) == 0 {
+					continue
+				}
+				b := ballots[rng.Intn(len(ballots))]
+				msgs[id] = NewMsg(id, 1, q, &PrepTopic{B: b, P: b})
+			}
+			preds := []func() predicate{
+				func() predicate {
+					return fpred(func(msg *Msg) bool { return msg.acceptsPrepared(ballots[0]) })
+				},
+				func() predicate {
+					return confirmPreparedPred(ballots, nil)
+				},
+			}
+
+			for id, q := range network {
+				others := make(map[NodeID]*Msg, len(msgs))
+				for k, v := range msgs {
+					if k != id {
+						others[k] = v
+					}
+				}
+				v := newQView(newNodeIndex(), id, q, others)
+				for j, pred := range preds {
+					got := v.findQuorum(pred())
+					want, _ := q.refFindQuorum(id, others, pred())
+					if !reflect.DeepEqual(got, want) {
+						t.Errorf("node %s, predicate %d: got quorum %v, want %v", id, j+1, got, want)
+					}
+					got = v.findBlockingSet(pred())
+					want, _ = q.refFindBlockingSet(others, pred())
+					if !reflect.DeepEqual(got, want) {
+						t.Errorf("node %s, predicate %d: got blocking set %v, want %v", id, j+1, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+// Finds a quorum and a blocking set accepting a ballot as prepared
+// in networks of various sizes, with qview and with the reference
+// implementation. In each network a fifth of the nodes prepare a
+// different ballot.
+func BenchmarkFindQuorum(b *testing.B) {
+	for _, n := range []int{10, 30, 100, 300, 1000} {
+		rng := rand.New(rand.NewSource(1))
+		network := tieredNetwork(rng, n)
+		self := NodeID(fmt.Sprintf("n%04d", n-1))
+		msgs := make(map[NodeID]*Msg)
+		for id, q := range network {
+			if id == self {
+				continue
+			}
+			x := valtype(1)
+			if rng.Intn(5) == 0 {
+				x = valtype(2)
+			}
+			msgs[id] = NewMsg(id, 1, q, &PrepTopic{B: Ballot{1, x}, P: Ballot{1, x}})
+		}
+		pred := fpred(func(msg *Msg) bool { return msg.acceptsPrepared(Ballot{1, valtype(1)}) })
+
+		b.Run(fmt.Sprintf("%d/reference", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				network[self].refFindQuorum(self, msgs, pred)
+				network[self].refFindBlockingSet(msgs, pred)
+			}
+		})
+		b.Run(fmt.Sprintf("%d/qview", n), func(b *testing.B) {
+			b.ReportAllocs()
+			v := newQView(newNodeIndex(), self, network[self], msgs)
+			for i := 0; i < b.N; i++ {
+				v.findQuorum(pred)
+				v.findBlockingSet(pred)
+				v.clearMemo()
+			}
+		})
+	}
+}
+
+// Produces a network of n nodes, named n0000, n0001, etc. The first
+// seven are its core. Each node requires two of: two-thirds of the
+// core (apart from itself), and two other nodes chosen at random.
+func tieredNetwork(rng *rand.Rand, n int) map[NodeID]QSet {
+	ids := make([]NodeID, n)
+	for i := range ids {
+		ids[i] = NodeID(fmt.Sprintf("n%04d", i))
+	}
+	ncore := 7
+	if n < ncore {
+		ncore = n
+	}
+	result := make(map[NodeID]QSet)
+	for _, id := range ids {
+		var core QSet
+		for _, other := range ids[:ncore] {
+			if other != id {
+				other := other
+				core.M = append(core.M, QSetMember{N: &other})
+			}
+		}
+		core.T = (2*len(core.M) + 2) / 3
+		q := QSet{T: 2, M: []QSetMember{{Q: &core}}}
+
+		var rest NodeIDSet
+		for _, other := range ids[ncore:] {
+			if other != id {
+				rest = append(rest, other)
+			}
+		}
+		rng.Shuffle(len(rest), func(i, j int) { rest[i], rest[j] = rest[j], rest[i] })
+		for _, other := range rest[:2] {
+			other := other
+			q.M = append(q.M, QSetMember{N: &other})
+		}
+		result[id] = q
+	}
+	return result
+}
+
+// This is the quorum search as it was before qview, kept as a
+// reference for testing and benchmarking qview.
+//
+// Checks that at least one node in each quorum slice satisfies pred
+// (excluding the slot's node).
+//
+// Works by finding len(q.M)-q.T+1 members for which pred is true
+func (q QSet) refFindBlockingSet(msgs map[NodeID]*Msg, pred predicate) (NodeIDSet, predicate) {
+	return refFindBlockingSetHelper(len(q.M)-q.T+1, q.M, msgs, pred, nil)
+}
+
+func refFindBlockingSetHelper(needed int, members []QSetMember, msgs map[NodeID]*Msg, pred predicate, sofar NodeIDSet) (NodeIDSet, predicate) {
+	if needed == 0 {
+		return sofar, pred
+	}
+	if needed > len(members) {
+		return nil, pred
+	}
+	m0 := members[0]
+	switch {
+	case m0.N != nil:
+		if msg, ok := msgs[*m0.N]; ok {
+			if nextPred := pred.test(msg); nextPred != nil {
+				return refFindBlockingSetHelper(needed-1, members[1:], msgs, nextPred, sofar.Add(*m0.N))
+			}
+		}
+
+	case m0.Q != nil:
+		sofar2, pred2 := refFindBlockingSetHelper(len(m0.Q.M)-m0.Q.T+1, m0.Q.M, msgs, pred, sofar)
+		if len(sofar2) > 0 {
+			return refFindBlockingSetHelper(needed-1, members[1:], msgs, pred2, sofar2)
+		}
+	}
+	return refFindBlockingSetHelper(needed, members[1:], msgs, pred, sofar)
+}
+
+// Finds a quorum in which every node satisfies the given
+// predicate. The slot's node itself is presumed to satisfy the
+// predicate.
+//
+// If pred is a confirmPredicate, the result may omit the
+// quorum members behind a node that has confirmed the statement
+// being tested. It is non-empty exactly when a quorum exists.
+func (q QSet) refFindQuorum(nodeID NodeID, m map[NodeID]*Msg, pred predicate) (NodeIDSet, predicate) {
+	return refFindQuorumHelper(q.T, q.M, m, pred, NodeIDSet{nodeID})
+}
+
+func refFindQuorumHelper(threshold int, members []QSetMember, msgs map[NodeID]*Msg, pred predicate, sofar NodeIDSet) (NodeIDSet, predicate) {
+	if threshold == 0 {
+		return sofar, pred
+	}
+	if threshold > len(members) {
+		return nil, pred
+	}
+	m0 := members[0]
+	switch {
+	case m0.N != nil:
+		if sofar.Contains(*m0.N) {
+			return refFindQuorumHelper(threshold-1, members[1:], msgs, pred, sofar)
+		}
+		if msg, ok := msgs[*m0.N]; ok {
+			if cp, ok := pred.(confirmPredicate); ok {
+				if nextPred := cp.confirmed(msg); nextPred != nil {
+					// This node has confirmed the statement, so it already
+					// has a satisfying quorum. No need to search its slices.
+					sofar2, pred2 := refFindQuorumHelper(threshold-1, members[1:], msgs, nextPred, sofar.Add(*m0.N))
+					if len(sofar2) > 0 {
+						return sofar2, pred2
+					}
+				}
+			}
+			if nextPred := pred.test(msg); nextPred != nil {
+				sofar2, pred2 := refFindQuorumHelper(msg.Q.T, msg.Q.M, msgs, nextPred, sofar.Add(*m0.N))
+				if len(sofar2) > 0 {
+					return refFindQuorumHelper(threshold-1, members[1:], msgs, pred2, sofar2)
+				}
+			}
+		}
+
+	case m0.Q != nil:
+		sofar2, pred2 := refFindQuorumHelper(m0.Q.T, m0.Q.M, msgs, pred, sofar)
+		if len(sofar2) > 0 {
+			return refFindQuorumHelper(threshold-1, members[1:], msgs, pred2, sofar2)
+		}
+	}
+	return refFindQuorumHelper(threshold, members[1:], msgs, pred, sofar)
+}
+
 func nodeIDPtr(s string) *NodeID {
 	return (*NodeID)(&s)
 }
@@ -524,3 +914,57 @@ func toNetwork(s string) map[NodeID][]NodeIDSet {
 	}
 	return result
 }
+
+// Separate searches for the same statement, like those made in one
+// call to Slot.advance, share memoized results.
+func TestMemoAcrossSearches(t *testing.T) {
+	peerQ := QSet{T: 3, M: []QSetMember{{N: nodeIDPtr("x")}, {N: nodeIDPtr("a")}, {N: nodeIDPtr("b")}, {N: nodeIDPtr("c")}}}
+	q := QSet{T: 2, M: peerQ.M[1:]}
+	b := Ballot{1, valtype(1)}
+	m := map[NodeID]*Msg{
+		"a": NewMsg("a", 1, peerQ, &CommitTopic{B: b, PN: 1, CN: 1, HN: 3}),
+		"b": NewMsg("b", 1, peerQ, &CommitTopic{B: b, PN: 1, CN: 2, HN: 4}),
+		"c": NewMsg("c", 1, peerQ, &CommitTopic{B: b, PN: 1, CN: 1, HN: 4}),
+	}
+	v := newQView(newNodeIndex(), "x", q, m)
+
+	var tests int
+	newPred := func() predicate {
+		return keyedPred("acceptsPrepared", ballotKey(b), func(msg *Msg) bool {
+			tests++
+			return msg.acceptsPrepared(b)
+		})
+	}
+	if got := v.findQuorum(newPred()); len(got) == 0 {
+		t.Fatal("no quorum")
+	}
+	first := tests
+	if got := v.findQuorum(newPred()); len(got) == 0 {
+		t.Fatal("no quorum on second search")
+	}
+	if tests != first {
+		t.Errorf("got %d test(s) on second search, want 0", tests-first)
+	}
+
+	// Predicates that narrow as they go still report their own
+	// results.
+	newMinMax := func(min, max *int) predicate {
+		return &minMaxPred{
+			min:      1,
+			max:      10,
+			finalMin: min,
+			finalMax: max,
+			testfn: func(msg *Msg, min, max int) (bool, int, int) {
+				return msg.acceptsCommit(b.X, min, max)
+			},
+			kind: "acceptsCommit",
+			arg:  valueKey(b.X),
+		}
+	}
+	var min1, max1, min2, max2 int
+	v.findQuorum(newMinMax(&min1, &max1))
+	v.findQuorum(newMinMax(&min2, &max2))
+	if min1 == 0 || min1 != min2 || max1 != max2 {
+		t.Errorf("got ranges [%d,%d] and [%d,%d], want the same nonzero range", min1, max1, min2, max2)
+	}
+}
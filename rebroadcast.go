@@ -0,0 +1,101 @@
+package scp
+
+import "sort"
+
+// A node whose messages are lost may never hear the responses it
+// needs to finish a slot. To recover, while the node has pending
+// slots, it periodically sends its latest message for each of them
+// again, along with the EXTERNALIZE messages for its most recent
+// externalized slots (see NodeConfig.RebroadcastInterval).
+//
+// There's no need to rebroadcast while the network is making
+// progress, so each time the timer fires having seen progress, its
+// interval doubles (up to NodeConfig.MaxRebroadcastInterval). Once
+// the node stalls, it rebroadcasts and the interval returns to
+// normal.
+
+// Arms the rebroadcast timer, unless it's already armed or
+// rebroadcasting is disabled.
+func (n *Node) scheduleRebroadcast() {
+	if n.rebroadcastTimer != nil || n.cfg.RebroadcastInterval < 0 {
+		return
+	}
+	if n.rebroadcastInterval == 0 {
+		n.rebroadcastInterval = n.cfg.RebroadcastInterval
+	}
+	n.rebroadcastTimer = n.clock.AfterFunc(n.rebroadcastInterval, func() {
+		n.cmds.writeHi(&rebroadcastCmd{})
+	})
+}
+
+func (n *Node) cancelRebroadcast() {
+	if n.rebroadcastTimer == nil {
+		return
+	}
+	n.rebroadcastTimer.Stop()
+	n.rebroadcastTimer = nil
+}
+
+// Called when the timer armed by scheduleRebroadcast fires.
+func (n *Node) rebroadcast() error {
+	if n.rebroadcastTimer == nil {
+		return nil
+	}
+	n.rebroadcastTimer = nil
+
+	if len(n.pending) == 0 {
+		// The timer is armed again when the next slot is created.
+		return nil
+	}
+
+	defer n.scheduleRebroadcast()
+
+	if n.progressed {
+		n.progressed = false
+		n.rebroadcastInterval *= 2
+		if n.rebroadcastInterval > n.cfg.MaxRebroadcastInterval {
+			n.rebroadcastInterval = n.cfg.MaxRebroadcastInterval
+		}
+		return nil
+	}
+	n.rebroadcastInterval = n.cfg.RebroadcastInterval
+
+	// Send in a deterministic order.
+	slots := make([]*Slot, 0, len(n.pending))
+	for _, s := range n.pending {
+		slots = append(slots, s)
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i].ID < slots[j].ID })
+
+	var count int
+	for _, s := range slots {
+		msg := s.Msg()
+		if msg == nil {
+			continue
+		}
+		err := n.transmit(msg)
+		if err != nil {
+			return err
+		}
+		count++
+	}
+
+	highest := n.ext.Highest()
+	for i := highest; i > 0 && int(highest-i) < n.cfg.RebroadcastExt; i-- {
+		topic, err := n.ext.Get(i)
+		if err != nil {
+			return err
+		}
+		if topic == nil {
+			continue
+		}
+		err = n.transmit(NewMsg(n.ID, i, n.Q, topic))
+		if err != nil {
+			return err
+		}
+		count++
+	}
+
+	n.log(LevelDebug, "rebroadcast", Field{"msgs", count})
+	return nil
+}
@@ -0,0 +1,122 @@
+package scp
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRebroadcast(t *testing.T) {
+	clock := NewFakeClock(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC))
+	ext := NewMemExtStore(0)
+	ext.Put(1, &ExtTopic{C: Ballot{1, valtype(1)}, HN: 1})
+	ch := make(chan *Msg, 10)
+	q := QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("y")}}}
+	yQ := QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("x")}}}
+	n := NewNode("x", q, ch, WithClock(clock), WithExtStore(ext))
+
+	drain := func() []*Msg {
+		var result []*Msg
+		for len(ch) > 0 {
+			result = append(result, <-ch)
+		}
+		return result
+	}
+
+	eligible, err := n.StepNominate(2, valtype(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !eligible {
+		t.Fatal("x not eligible to nominate")
+	}
+	sent := drain()
+	if len(sent) != 1 {
+		t.Fatalf("got %d message(s), want 1", len(sent))
+	}
+
+	// The node made progress, so it backs off instead of
+	// rebroadcasting.
+	clock.Advance(2 * time.Second)
+	for n.Step() {
+	}
+	if got := drain(); len(got) > 0 {
+		t.Errorf("got %d message(s), want none", len(got))
+	}
+	if n.rebroadcastInterval != 4*time.Second {
+		t.Errorf("got interval %s, want 4s", n.rebroadcastInterval)
+	}
+
+	// Now it has stalled.
+	clock.Advance(4 * time.Second)
+	for n.Step() {
+	}
+	got := drain()
+	if len(got) != 2 {
+		t.Fatalf("got %d message(s), want 2", len(got))
+	}
+	if got[0].I != 2 || !reflect.DeepEqual(got[0].T, sent[0].T) {
+		t.Errorf("got %s, want %s", got[0], sent[0])
+	}
+	if topic, ok := got[1].T.(*ExtTopic); !ok || got[1].I != 1 || !ValueEqual(topic.C.X, valtype(1)) {
+		t.Errorf("got %s, want EXTERNALIZE of slot 1", got[1])
+	}
+	if n.rebroadcastInterval != 2*time.Second {
+		t.Errorf("got interval %s, want 2s", n.rebroadcastInterval)
+	}
+
+	// Once the slot externalizes, there's nothing left to rebroadcast.
+	err = handleNow(n, NewMsg("y", 2, yQ, &ExtTopic{C: Ballot{1, valtype(7)}, HN: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := clock.Pending(); got != 0 {
+		t.Errorf("got %d pending timer(s), want 0", got)
+	}
+}
+
+func TestRebroadcastExt(t *testing.T) {
+	cases := []struct {
+		rebroadcastExt int
+		want           []SlotID
+	}{
+		{rebroadcastExt: 0, want: []SlotID{3, 2}},
+		{rebroadcastExt: 2, want: []SlotID{3, 2, 1}},
+		{rebroadcastExt: 5, want: []SlotID{3, 2, 1}},
+		{rebroadcastExt: -1, want: []SlotID{3}},
+	}
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%d", tc.rebroadcastExt), func(t *testing.T) {
+			clock := NewFakeClock(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC))
+			ext := NewMemExtStore(0)
+			for i := SlotID(1); i <= 2; i++ {
+				ext.Put(i, &ExtTopic{C: Ballot{1, valtype(i)}, HN: 1})
+			}
+			ch := make(chan *Msg, 10)
+			q := QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("y")}}}
+			cfg := NodeConfig{RebroadcastExt: tc.rebroadcastExt}
+			n := NewNode("x", q, ch, WithClock(clock), WithExtStore(ext), WithConfig(cfg))
+
+			if _, err := n.StepNominate(3, valtype(7)); err != nil {
+				t.Fatal(err)
+			}
+			<-ch
+
+			// Back off once, then stall.
+			clock.Advance(2 * time.Second)
+			for n.Step() {
+			}
+			clock.Advance(4 * time.Second)
+			for n.Step() {
+			}
+			var got []SlotID
+			for len(ch) > 0 {
+				got = append(got, (<-ch).I)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("rebroadcast slots %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -245,6 +245,18 @@ func (bs BallotSet) Remove(b Ballot) BallotSet {
 	return result
 }
 
+// Produces a BallotSet with only the members of bs for which f
+// returns true.
+func (bs BallotSet) filter(f func(Ballot) bool) BallotSet {
+	var result BallotSet
+	for _, b := range bs {
+		if f(b) {
+			result = append(result, b)
+		}
+	}
+	return result
+}
+
 // Contains tests whether bs contains b.
 func (bs BallotSet) Contains(b Ballot) bool {
 	index := bs.find(b)
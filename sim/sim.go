@@ -0,0 +1,327 @@
+// Package sim is a deterministic, in-memory simulator for networks of
+// SCP nodes.
+//
+// A Network owns a set of nodes, a virtual clock, and a seeded random
+// number generator. It drives each node with Node.Step (rather than
+// Node.Run), delivering every message a node sends to all of the
+// node's peers in the network after a simulated latency, possibly
+// dropping or reordering messages along the way. Given the same
+// configuration, seed, and inputs, a run produces exactly the same
+// message trace every time.
+package sim
+
+import (
+	"container/heap"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/bobg/scp"
+)
+
+// Config holds the parameters of a simulated network.
+type Config struct {
+	// Seed seeds the network's random number generator.
+	Seed int64
+
+	// Start is the initial time of the network's virtual clock. If
+	// it is zero, a fixed default is used.
+	Start time.Time
+
+	// Latency is the minimum time it takes to deliver a message.
+	Latency time.Duration
+
+	// Jitter is the maximum additional random latency added to each
+	// message.
+	Jitter time.Duration
+
+	// Loss is the probability, in [0,1], that a message is dropped.
+	Loss float64
+
+	// Reorder is the probability, in [0,1], that a message may be
+	// delivered before earlier messages on the same link. Other
+	// messages are delivered in the order they were sent (to a given
+	// recipient from a given sender), even if jitter would say
+	// otherwise.
+	Reorder float64
+}
+
+// DefaultStart is the virtual clock's starting time when
+// Config.Start is zero.
+var DefaultStart = time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Network is a simulated network of SCP nodes.
+type Network struct {
+	cfg   Config
+	rng   *rand.Rand
+	clock *scp.FakeClock
+
+	ids   []scp.NodeID // sorted
+	nodes map[scp.NodeID]*simNode
+
+	queue   deliveries
+	seq     int
+	lastDlv map[link]time.Time
+
+	trace []Event
+	ext   map[scp.SlotID]map[scp.NodeID]scp.Value
+}
+
+type simNode struct {
+	node *scp.Node
+	ch   chan *scp.Msg
+}
+
+type link struct {
+	from, to scp.NodeID
+}
+
+// Event is an entry in a network's message trace.
+type Event struct {
+	At       time.Time
+	From, To scp.NodeID
+	Msg      *scp.Msg
+	Dropped  bool
+}
+
+// String produces a readable representation of an event. It omits
+// the message's envelope counter (Msg.C), which is not reproducible
+// across runs in the same process.
+func (e Event) String() string {
+	var dropped string
+	if e.Dropped {
+		dropped = " (dropped)"
+	}
+	return fmt.Sprintf("%s %s -> %s I=%d: %s%s", e.At.Format("15:04:05.000000"), e.From, e.To, e.Msg.I, e.Msg.T, dropped)
+}
+
+// New produces a new, empty Network.
+func New(cfg Config) *Network {
+	if cfg.Start.IsZero() {
+		cfg.Start = DefaultStart
+	}
+	return &Network{
+		cfg:     cfg,
+		rng:     rand.New(rand.NewSource(cfg.Seed)),
+		clock:   scp.NewFakeClock(cfg.Start),
+		nodes:   make(map[scp.NodeID]*simNode),
+		lastDlv: make(map[link]time.Time),
+		ext:     make(map[scp.SlotID]map[scp.NodeID]scp.Value),
+	}
+}
+
+// Clock returns the network's virtual clock.
+func (net *Network) Clock() *scp.FakeClock {
+	return net.clock
+}
+
+// AddNode adds a new node to the network. The node uses the
+// network's clock; any other options are passed to scp.NewNode.
+func (net *Network) AddNode(id scp.NodeID, q scp.QSet, opts ...scp.NodeOption) *scp.Node {
+	ch := make(chan *scp.Msg, 4096)
+	opts = append([]scp.NodeOption{scp.WithClock(net.clock)}, opts...)
+	node := scp.NewNode(id, q, ch, opts...)
+	net.nodes[id] = &simNode{node: node, ch: ch}
+
+	index := sort.Search(len(net.ids), func(i int) bool { return net.ids[i] >= id })
+	net.ids = append(net.ids, "")
+	copy(net.ids[index+1:], net.ids[index:])
+	net.ids[index] = id
+
+	return node
+}
+
+// Node returns the node with the given ID, or nil if there is none.
+func (net *Network) Node(id scp.NodeID) *scp.Node {
+	if sn, ok := net.nodes[id]; ok {
+		return sn.node
+	}
+	return nil
+}
+
+// Nominate causes the given node to nominate v for the given slot. It
+// reports whether the node is eligible to nominate (see
+// scp.Node.Nominate).
+func (net *Network) Nominate(id scp.NodeID, slotID scp.SlotID, v scp.Value) (bool, error) {
+	sn := net.nodes[id]
+	eligible, err := sn.node.StepNominate(slotID, v)
+
+	// Send what the request produced
+	// and finish anything it left queued.
+	net.drain(sn)
+	for sn.node.Step() {
+		net.drain(sn)
+	}
+	return eligible, err
+}
+
+// Trace returns the network's message trace so far.
+func (net *Network) Trace() []Event {
+	return net.trace
+}
+
+// Externalized returns the values externalized for the given slot so
+// far, keyed by node ID.
+func (net *Network) Externalized(slotID scp.SlotID) map[scp.NodeID]scp.Value {
+	return net.ext[slotID]
+}
+
+// AllExternalized tells whether every node in the network has
+// externalized a value for the given slot.
+func (net *Network) AllExternalized(slotID scp.SlotID) bool {
+	return len(net.ext[slotID]) == len(net.nodes)
+}
+
+// Run runs the simulation until done returns true or the virtual
+// clock would pass the given limit, whichever comes first. It
+// reports whether done returned true.
+func (net *Network) Run(limit time.Duration, done func() bool) bool {
+	end := net.clock.Now().Add(limit)
+	for {
+		net.settle()
+		if done() {
+			return true
+		}
+
+		// Find the next thing to happen:
+		// a message delivery or a timer firing.
+		next, ok := net.clock.Next()
+		if len(net.queue) > 0 && (!ok || !next.Before(net.queue[0].at)) {
+			next, ok = net.queue[0].at, true
+		}
+		if !ok || next.After(end) {
+			return false
+		}
+
+		// This fires any timers due by then.
+		net.clock.AdvanceTo(next)
+
+		if len(net.queue) > 0 && !net.queue[0].at.After(next) {
+			d := heap.Pop(&net.queue).(*delivery)
+			// The node's queue was emptied by settle,
+			// so this shouldn't fail for lack of room.
+			err := net.nodes[d.to].node.Handle(d.msg)
+			net.trace = append(net.trace, Event{
+				At:      d.at,
+				From:    d.msg.V,
+				To:      d.to,
+				Msg:     d.msg,
+				Dropped: err != nil,
+			})
+		}
+	}
+}
+
+// Runs every node until there's nothing left to do.
+func (net *Network) settle() {
+	for {
+		var progress bool
+		for _, id := range net.ids {
+			sn := net.nodes[id]
+			for sn.node.Step() {
+				progress = true
+				net.drain(sn)
+			}
+		}
+		if !progress {
+			return
+		}
+	}
+}
+
+// Takes the messages a node has sent and schedules their delivery.
+func (net *Network) drain(sn *simNode) {
+	for {
+		select {
+		case msg := <-sn.ch:
+			net.send(msg)
+		default:
+			return
+		}
+	}
+}
+
+func (net *Network) send(msg *scp.Msg) {
+	if topic, ok := msg.T.(*scp.ExtTopic); ok {
+		m := net.ext[msg.I]
+		if m == nil {
+			m = make(map[scp.NodeID]scp.Value)
+			net.ext[msg.I] = m
+		}
+		m[msg.V] = topic.C.X
+	}
+
+	now := net.clock.Now()
+	for _, to := range net.ids {
+		if to == msg.V {
+			continue
+		}
+		if net.cfg.Loss > 0 && net.rng.Float64() < net.cfg.Loss {
+			net.trace = append(net.trace, Event{
+				At:      now,
+				From:    msg.V,
+				To:      to,
+				Msg:     msg,
+				Dropped: true,
+			})
+			continue
+		}
+		at := now.Add(net.cfg.Latency)
+		if net.cfg.Jitter > 0 {
+			at = at.Add(time.Duration(net.rng.Int63n(int64(net.cfg.Jitter))))
+		}
+		l := link{from: msg.V, to: to}
+		if net.cfg.Reorder == 0 || net.rng.Float64() >= net.cfg.Reorder {
+			if last := net.lastDlv[l]; at.Before(last) {
+				at = last
+			}
+		}
+		if at.After(net.lastDlv[l]) {
+			net.lastDlv[l] = at
+		}
+		net.seq++
+		heap.Push(&net.queue, &delivery{
+			at:  at,
+			seq: net.seq,
+			to:  to,
+			msg: msg,
+		})
+	}
+}
+
+type delivery struct {
+	at  time.Time
+	seq int
+	to  scp.NodeID
+	msg *scp.Msg
+}
+
+// Implements heap.Interface.
+type deliveries []*delivery
+
+func (d deliveries) Len() int { return len(d) }
+
+func (d deliveries) Less(i, j int) bool {
+	if d[i].at.Before(d[j].at) {
+		return true
+	}
+	if d[j].at.Before(d[i].at) {
+		return false
+	}
+	return d[i].seq < d[j].seq
+}
+
+func (d deliveries) Swap(i, j int) { d[i], d[j] = d[j], d[i] }
+
+func (d *deliveries) Push(x interface{}) {
+	*d = append(*d, x.(*delivery))
+}
+
+func (d *deliveries) Pop() interface{} {
+	old := *d
+	n := len(old)
+	result := old[n-1]
+	*d = old[:n-1]
+	return result
+}
@@ -0,0 +1,216 @@
+package sim
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bobg/scp"
+)
+
+type valtype string
+
+func (v valtype) Less(other scp.Value) bool { return v < other.(valtype) }
+
+func (v valtype) Combine(other scp.Value, _ scp.SlotID) scp.Value {
+	if v < other.(valtype) {
+		return v
+	}
+	return other
+}
+
+func (v valtype) IsNil() bool    { return v == "" }
+func (v valtype) Bytes() []byte  { return []byte(v) }
+func (v valtype) String() string { return string(v) }
+
+// The "3tiers" network from cmd/lunch/toml.
+func threeTiers() map[scp.NodeID]scp.QSet {
+	top := []scp.NodeID{"alice", "bob", "carol", "dave"}
+	mid := []scp.NodeID{"elsie", "fred", "gwen", "hank"}
+	result := make(map[scp.NodeID]scp.QSet)
+	for _, id := range top {
+		var others []scp.NodeID
+		for _, other := range top {
+			if other != id {
+				others = append(others, other)
+			}
+		}
+		result[id] = qset(2, others...)
+	}
+	for _, id := range mid {
+		result[id] = qset(2, top...)
+	}
+	result["inez"] = qset(2, mid...)
+	result["john"] = qset(2, mid...)
+	return result
+}
+
+func qset(t int, ids ...scp.NodeID) scp.QSet {
+	result := scp.QSet{T: t}
+	for _, id := range ids {
+		id := id
+		result.M = append(result.M, scp.QSetMember{N: &id})
+	}
+	return result
+}
+
+func newNetwork(cfg Config, opts ...scp.NodeOption) *Network {
+	net := New(cfg)
+	for id, q := range threeTiers() {
+		net.AddNode(id, q, opts...)
+	}
+	return net
+}
+
+var foods = []valtype{"burgers", "burritos", "gyros", "indian", "pasta", "pizza", "salads", "sandwiches", "soup", "sushi"}
+
+func runSlot(net *Network, slotID scp.SlotID, limit time.Duration) bool {
+	for i, id := range net.ids {
+		_, err := net.Nominate(id, slotID, foods[(i+int(slotID))%len(foods)])
+		if err != nil {
+			panic(err)
+		}
+	}
+	return net.Run(limit, func() bool { return net.AllExternalized(slotID) })
+}
+
+func traceStrings(net *Network) []string {
+	var result []string
+	for _, e := range net.Trace() {
+		result = append(result, e.String())
+	}
+	return result
+}
+
+func TestConsensus(t *testing.T) {
+	net := newNetwork(Config{
+		Seed:    1,
+		Latency: 10 * time.Millisecond,
+		Jitter:  50 * time.Millisecond,
+		Reorder: 0.1,
+	})
+	for slotID := scp.SlotID(1); slotID <= 3; slotID++ {
+		if !runSlot(net, slotID, time.Hour) {
+			t.Fatalf("slot %d: not all nodes externalized (got %v)", slotID, net.Externalized(slotID))
+		}
+		var want scp.Value
+		for id, v := range net.Externalized(slotID) {
+			if want == nil {
+				want = v
+			} else if !scp.ValueEqual(v, want) {
+				t.Errorf("slot %d: node %s externalized %s, others %s", slotID, id, v, want)
+			}
+		}
+	}
+}
+
+// Rebroadcasting lets the network recover from lost messages.
+func TestLossyConsensus(t *testing.T) {
+	var stalls int
+	for seed := int64(1); seed <= 10; seed++ {
+		cfg := Config{
+			Seed:    seed,
+			Latency: 10 * time.Millisecond,
+			Jitter:  50 * time.Millisecond,
+			Loss:    0.4,
+		}
+		if !runSlots(newNetwork(cfg), 3) {
+			t.Fatalf("seed %d: not all nodes externalized", seed)
+		}
+
+		// The control: without rebroadcasting, the network may stall.
+		net := newNetwork(cfg, scp.WithConfig(scp.NodeConfig{RebroadcastInterval: -1}))
+		if !runSlots(net, 3) {
+			stalls++
+		}
+	}
+	if stalls == 0 {
+		t.Error("no network stalled without rebroadcasting")
+	}
+}
+
+// Runs slots 1 through n in turn,
+// reporting whether all nodes externalized all of them.
+func runSlots(net *Network, n scp.SlotID) bool {
+	for slotID := scp.SlotID(1); slotID <= n; slotID++ {
+		if !runSlot(net, slotID, time.Hour) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDeterminism(t *testing.T) {
+	cfg := Config{
+		Seed:    17,
+		Latency: 5 * time.Millisecond,
+		Jitter:  100 * time.Millisecond,
+		Loss:    0.05,
+		Reorder: 0.5,
+	}
+	var traces [][]string
+	for i := 0; i < 3; i++ {
+		net := newNetwork(cfg)
+		runSlot(net, 1, time.Minute)
+		traces = append(traces, traceStrings(net))
+	}
+	if len(traces[0]) == 0 {
+		t.Fatal("empty trace")
+	}
+	for i := 1; i < len(traces); i++ {
+		if !reflect.DeepEqual(traces[0], traces[i]) {
+			t.Errorf("trace %d differs from trace 0", i)
+		}
+	}
+}
+
+type recorder struct {
+	scp.NopObserver
+	created bool
+	phases  []scp.Phase
+	ext     scp.Ballot
+}
+
+func (r *recorder) SlotCreated(i scp.SlotID) {
+	r.created = true
+}
+
+func (r *recorder) PhaseChanged(i scp.SlotID, from, to scp.Phase, b scp.Ballot) {
+	r.phases = append(r.phases, to)
+}
+
+func (r *recorder) Externalized(i scp.SlotID, c scp.Ballot) {
+	r.ext = c
+}
+
+func TestObserver(t *testing.T) {
+	net := New(Config{Seed: 1, Latency: 10 * time.Millisecond})
+	recorders := make(map[scp.NodeID]*recorder)
+	for id, q := range threeTiers() {
+		r := new(recorder)
+		recorders[id] = r
+		net.AddNode(id, q, scp.WithObserver(r))
+	}
+	if !runSlot(net, 1, time.Hour) {
+		t.Fatal("not all nodes externalized")
+	}
+	for id, r := range recorders {
+		if !r.created {
+			t.Errorf("node %s: no SlotCreated", id)
+		}
+		// Depending on timing, a node may skip phases,
+		// but it never goes backward.
+		for i := 1; i < len(r.phases); i++ {
+			if r.phases[i] <= r.phases[i-1] {
+				t.Errorf("node %s: got phases %v", id, r.phases)
+				break
+			}
+		}
+		if len(r.phases) == 0 || r.phases[len(r.phases)-1] != scp.PhExt {
+			t.Errorf("node %s: got phases %v, want EXTERNALIZE last", id, r.phases)
+		}
+		if got := net.Externalized(1)[id]; !scp.ValueEqual(r.ext.X, got) {
+			t.Errorf("node %s: observer got %s, want %s", id, r.ext.X, got)
+		}
+	}
+}
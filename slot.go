@@ -2,8 +2,10 @@ package scp
 
 import (
 	"bytes"
+	"fmt"
 	"math"
 	"reflect"
+	"strconv"
 	"time"
 )
 
@@ -24,15 +26,20 @@ type Slot struct {
 	Y ValueSet  // votes for accept(nominate(val))
 	Z ValueSet  // confirmed nominated values
 
+	cands          ValueSet  // the node's own candidate values (see Node.Nominate)
 	maxPriPeers    NodeIDSet // set of peers that have ever had max priority
 	lastRound      int       // latest round at which maxPriPeers was updated
-	nextRoundTimer *time.Timer
+	nextRoundTimer Timer
 
 	B     Ballot
 	P, PP Ballot // two highest "accepted prepared" ballots with differing values
 	C, H  Ballot // lowest and highest confirmed-prepared or accepted-commit ballots (depending on phase)
 
-	Upd *time.Timer // timer for invoking a deferred update
+	Upd Timer // timer for invoking a deferred update
+
+	bnTimer Timer // timer for raising B.N once the cap on it allows (see updateB)
+
+	qv *qview // indexed form of M for searching, kept up to date by handle (see view)
 }
 
 // Phase is the type of a slot's phase.
@@ -46,12 +53,28 @@ const (
 	PhExt
 )
 
+func (ph Phase) String() string {
+	switch ph {
+	case PhNom:
+		return "NOMINATE"
+	case PhNomPrep:
+		return "NOMINATE/PREPARE"
+	case PhPrep:
+		return "PREPARE"
+	case PhCommit:
+		return "COMMIT"
+	case PhExt:
+		return "EXTERNALIZE"
+	}
+	return fmt.Sprintf("Phase(%d)", int(ph))
+}
+
 func newSlot(id SlotID, n *Node) (*Slot, error) {
 	s := &Slot{
 		ID: id,
 		V:  n,
 		Ph: PhNom,
-		T:  time.Now(),
+		T:  n.clock.Now(),
 		M:  make(map[NodeID]*Msg),
 	}
 	peerID, err := s.findMaxPriPeer(1)
@@ -64,53 +87,23 @@ func newSlot(id SlotID, n *Node) (*Slot, error) {
 	return s, nil
 }
 
-var (
-	// NomRoundInterval determines the duration of a nomination "round."
-	// Round N lasts for a duration of (2+N)*NomRoundInterval.  A node's
-	// neighbor set changes from one round to the next, as do the
-	// priorities of the peers in that set.
-	NomRoundInterval = time.Second
-
-	// DeferredUpdateInterval determines the delay between arming a
-	// deferred-update timer and firing it. The delay is
-	// (1+N)*DeferredUpdateInterval, where N is the value of the slot's
-	// ballot counter (B.N).
-	DeferredUpdateInterval = time.Second
-)
-
 // This embodies most of the nomination and balloting protocols. It
 // processes an incoming protocol message and returns an outbound
 // protocol message in response, or nil if the incoming message is
 // ignored.
-// TODO: prune quorum searches when receiving a "confirm" vote. ("Once
-// "v" enters the confirmed state, it may issue a _confirm_ "a"
-// message to help other nodes confirm "a" more efficiently by pruning
-// their quorum search at "v".")
-func (s *Slot) handle(msg *Msg) (resp *Msg, err error) {
-	if s.V.ID == msg.V && !s.isNomPhase() {
-		// A node doesn't message itself except during nomination.
+func (s *Slot) handle(msg *Msg) (*Msg, error) {
+	if s.V.ID == msg.V {
+		// A node doesn't message itself. (It proposes values with
+		// Node.Nominate.)
 		return nil, nil
 	}
 
-	err = msg.valid()
+	err := msg.valid()
 	if err != nil {
 		return nil, err
 	}
 
-	defer func() {
-		if err == nil {
-			if resp != nil {
-				if s.sent != nil && reflect.DeepEqual(resp.T, s.sent.T) {
-					resp = nil
-				} else {
-					s.sent = resp
-				}
-			}
-			if resp != nil {
-				s.Logf("%s -> %v", msg, resp)
-			}
-		}
-	}()
+	defer s.notify(s.snapshot())
 
 	if have, ok := s.M[msg.V]; ok && !have.T.Less(msg.T) {
 		// We already have a message from this sender that's the same or
@@ -118,10 +111,60 @@ func (s *Slot) handle(msg *Msg) (resp *Msg, err error) {
 		msg = have
 	} else {
 		s.M[msg.V] = msg
+		if s.qv != nil {
+			s.qv.setMsg(msg)
+		}
+	}
+
+	if s.isNomPhase() {
+		s.echo(msg)
+	}
+
+	resp := s.advance()
+	if resp != nil {
+		s.log(LevelDebug, "handled message", Field{KeySender, msg.V}, Field{KeyMsg, msg}, Field{KeyResp, resp})
+	}
+	return resp, nil
+}
+
+// Adds vals to the node's candidate values for this slot and votes to
+// nominate them if the node is eligible (see offerCands). Returns
+// whether the node is eligible, and the outbound protocol message
+// that results, if any.
+func (s *Slot) nominate(vals ValueSet) (bool, *Msg) {
+	defer s.notify(s.snapshot())
+
+	s.cands = s.cands.Union(vals)
+	eligible := s.offerCands()
+	return eligible, s.advance()
+}
+
+// The node is eligible to nominate its own candidate values in a
+// round if it is one of the peers with the maximum priority (in this
+// or any earlier round; see maxPriPeers). If it is, and if no value
+// has yet been confirmed nominated, this votes to nominate them.
+// Reports whether the node is eligible.
+func (s *Slot) offerCands() bool {
+	if !s.maxPrioritySender(s.V.ID) {
+		return false
+	}
+	if s.isNomPhase() && len(s.Z) == 0 {
+		s.X = s.X.Union(s.cands.Minus(s.Y))
+	}
+	return true
+}
+
+// Carries nomination and balloting as far as the slot's current state
+// allows. Returns the outbound protocol message that results, or nil
+// if it's the same as the last one sent.
+func (s *Slot) advance() *Msg {
+	if s.qv != nil {
+		// Memoized results last for one command (see qview).
+		s.qv.clearMemo()
 	}
 
 	if s.isNomPhase() {
-		s.doNomPhase(msg)
+		s.doNomPhase()
 	}
 
 	if s.isPrepPhase() {
@@ -132,7 +175,14 @@ func (s *Slot) handle(msg *Msg) (resp *Msg, err error) {
 		s.doCommitPhase()
 	}
 
-	return s.Msg(), nil
+	resp := s.Msg()
+	if resp == nil {
+		return nil
+	}
+	if s.sent != nil && reflect.DeepEqual(resp.T, s.sent.T) {
+		return nil
+	}
+	return resp
 }
 
 func (s *Slot) isNomPhase() bool {
@@ -143,21 +193,26 @@ func (s *Slot) isPrepPhase() bool {
 	return s.Ph == PhNomPrep || s.Ph == PhPrep
 }
 
-func (s *Slot) doNomPhase(msg *Msg) {
-	if len(s.Z) == 0 && s.maxPrioritySender(msg.V) {
-		// "Echo" nominated values by adding them to s.X.
-		f := func(topic *NomTopic) {
-			s.X = s.X.Union(topic.X)
-			s.X = s.X.Union(topic.Y)
-		}
-		switch topic := msg.T.(type) {
-		case *NomTopic:
-			f(topic)
-		case *NomPrepTopic:
-			f(&topic.NomTopic)
-		}
+// "Echoes" the values nominated in msg by adding them to s.X, if the
+// sender has (or had) the maximum priority and no value has yet been
+// confirmed nominated.
+func (s *Slot) echo(msg *Msg) {
+	if len(s.Z) > 0 || !s.maxPrioritySender(msg.V) {
+		return
+	}
+	f := func(topic *NomTopic) {
+		s.X = s.X.Union(topic.X)
+		s.X = s.X.Union(topic.Y)
 	}
+	switch topic := msg.T.(type) {
+	case *NomTopic:
+		f(topic)
+	case *NomPrepTopic:
+		f(&topic.NomTopic)
+	}
+}
 
+func (s *Slot) doNomPhase() {
 	// Promote accepted-nominated values from X to Y, and
 	// confirmed-nominated values from Y to Z.
 	s.updateYZ()
@@ -182,39 +237,17 @@ func (s *Slot) doNomPhase(msg *Msg) {
 func (s *Slot) doPrepPhase() {
 	s.updateP() // xxx may be redundant with the call in doNomPhase
 
-	// Update s.H, the highest confirmed-prepared ballot.
-	s.H = ZeroBallot
-	var cpIn, cpOut BallotSet
-	if !s.P.IsZero() {
-		cpIn = cpIn.Add(s.P)
-		if !s.PP.IsZero() {
-			cpIn = cpIn.Add(s.PP)
-		}
-	}
-	nodeIDs := s.findQuorum(&ballotSetPred{
-		ballots:      cpIn,
-		finalBallots: &cpOut,
-		testfn: func(msg *Msg, ballots BallotSet) BallotSet {
-			return ballots.Intersection(msg.acceptsPreparedSet())
-		},
-	})
-	if len(nodeIDs) > 0 {
-		h := cpOut[len(cpOut)-1]
-		if ValueEqual(s.B.X, h.X) {
-			s.H = h
-		}
-		if s.Ph == PhNomPrep {
-			// Some ballot is confirmed prepared, exit NOMINATE phase.
-			s.Ph = PhPrep
-			s.cancelRounds()
-		}
+	if s.updateH() && s.Ph == PhNomPrep {
+		// Some ballot is confirmed prepared, exit NOMINATE phase.
+		s.Ph = PhPrep
+		s.cancelRounds()
 	}
 
 	s.updateB()
 
 	// Update s.C.
 	if !s.C.IsZero() {
-		if s.H.N == 0 || (s.C.Less(s.P) && !ValueEqual(s.P.X, s.C.X)) || (s.C.Less(s.PP) && !ValueEqual(s.PP.X, s.C.X)) {
+		if s.H.N == 0 || !ValueEqual(s.C.X, s.B.X) || (s.C.Less(s.P) && !ValueEqual(s.P.X, s.C.X)) || (s.C.Less(s.PP) && !ValueEqual(s.PP.X, s.C.X)) {
 			s.C = ZeroBallot
 		}
 	}
@@ -231,6 +264,61 @@ func (s *Slot) doPrepPhase() {
 	}
 }
 
+// Update s.H, the highest confirmed-prepared ballot. If its value
+// isn't that of s.B, s.B switches to it, keeping its counter if that
+// is higher: a node must not keep working on one value after the
+// network has confirmed another prepared, or it can stall forever.
+// Reports whether any ballot is confirmed prepared.
+func (s *Slot) updateH() bool {
+	s.H = ZeroBallot
+
+	var cpIn BallotSet
+	if !s.P.IsZero() {
+		cpIn = cpIn.Add(s.P)
+		if !s.PP.IsZero() {
+			cpIn = cpIn.Add(s.PP)
+		}
+	}
+	cpOut := maxBallotSet(cpIn, func(b Ballot) bool {
+		return len(s.findQuorum(confirmPreparedPred(BallotSet{b}, nil))) > 0
+	})
+	if len(cpOut) == 0 {
+		return false
+	}
+	h := cpOut[len(cpOut)-1]
+	if !ValueEqual(s.B.X, h.X) {
+		if s.B.N < h.N {
+			s.B.N = h.N
+		}
+		s.B.X = h.X
+		s.cancelUpd()
+	}
+	s.H = h
+	return true
+}
+
+// Produces a predicate for finding a quorum that accepts some of the
+// given ballots as prepared, i.e. for confirming them prepared.
+func confirmPreparedPred(ballots BallotSet, final *BallotSet) *ballotSetPred {
+	return &ballotSetPred{
+		ballots:      ballots,
+		finalBallots: final,
+		testfn: func(msg *Msg, ballots BallotSet) BallotSet {
+			return ballots.filter(msg.acceptsPrepared)
+		},
+		confirmfn: func(msg *Msg, ballots BallotSet) BallotSet {
+			h := msg.confirmsPrepared()
+			if h.IsZero() {
+				return nil
+			}
+			return ballots.filter(func(b Ballot) bool {
+				return b.N <= h.N && ValueEqual(b.X, h.X)
+			})
+		},
+		kind: "acceptsPreparedSet",
+	}
+}
+
 func (s *Slot) doCommitPhase() {
 	s.cancelRounds()
 	s.updateP()
@@ -239,57 +327,107 @@ func (s *Slot) doCommitPhase() {
 
 	// As soon as a node confirms "commit b" for any ballot "b", it
 	// moves to the EXTERNALIZE stage.
-	var cn, hn int
+	var (
+		cn, hn int
+		x      = s.B.X
+	)
 	nodeIDs := s.findQuorum(&minMaxPred{
 		min:      s.C.N,
 		max:      s.H.N,
 		finalMin: &cn,
 		finalMax: &hn,
 		testfn: func(msg *Msg, min, max int) (bool, int, int) {
-			return msg.acceptsCommit(s.B.X, min, max)
+			return msg.acceptsCommit(x, min, max)
 		},
+		confirmfn: func(msg *Msg, min, max int) (bool, int, int) {
+			return msg.confirmsCommit(x, min, max)
+		},
+		kind: "acceptsCommit",
+		arg:  valueKey(x),
 	})
 	if len(nodeIDs) > 0 {
 		s.Ph = PhExt // \o/
 		s.C.N = cn
 		s.H.N = hn
 		s.cancelUpd()
+		s.cancelBN()
 	}
 }
 
+// Stops the slot's timers. It's called when the slot is no longer
+// pending (because it has externalized) and when the node stops
+// running. Commands for the slot that are already queued are
+// discarded (see Node.isLive).
+func (s *Slot) close() {
+	s.cancelRounds()
+	s.cancelUpd()
+	s.cancelBN()
+	s.qv = nil
+}
+
 func (s *Slot) cancelRounds() {
 	if s.nextRoundTimer == nil {
 		return
 	}
-	stopTimer(s.nextRoundTimer)
+	s.nextRoundTimer.Stop()
 	s.nextRoundTimer = nil
 }
 
+// Updates s.C and s.H to the range of counters for which the node
+// accepts commit(<n, s.B.X>), and reports whether there is one. A
+// range accepted by peers is preferred when it is higher than the one
+// the node already accepts: otherwise a node that accepted a low
+// range would keep it after the network had moved on to a higher one,
+// and could never confirm a commit.
 func (s *Slot) updateAcceptsCommitBounds() bool {
-	var cn, hn int
-	nodeIDs := s.accept(func(isQuorum bool) predicate {
-		return &minMaxPred{
-			min:      1,
-			max:      math.MaxInt32,
-			finalMin: &cn,
-			finalMax: &hn,
-			testfn: func(msg *Msg, min, max int) (bool, int, int) {
-				rangeFn := msg.acceptsCommit
-				if isQuorum {
-					rangeFn = msg.votesOrAcceptsCommit
-				}
-				return rangeFn(s.B.X, min, max)
-			},
+	pred := func(cn, hn *int) func(bool) predicate {
+		return func(isQuorum bool) predicate {
+			kind := "acceptsCommit"
+			rangeFn := (*Msg).acceptsCommit
+			if isQuorum {
+				kind = "votesOrAcceptsCommit"
+				rangeFn = (*Msg).votesOrAcceptsCommit
+			}
+			x := s.B.X
+			return &minMaxPred{
+				min:      1,
+				max:      math.MaxInt32,
+				finalMin: cn,
+				finalMax: hn,
+				testfn: func(msg *Msg, min, max int) (bool, int, int) {
+					return rangeFn(msg, x, min, max)
+				},
+				kind: kind,
+				arg:  valueKey(x),
+			}
 		}
-	})
-	if len(nodeIDs) > 0 {
+	}
+
+	// This is s.accept, with its first step (whether the node itself
+	// accepts) kept apart from the others.
+	var (
+		cn, hn, peerCN, peerHN int
+		found                  bool
+	)
+	if s.sent != nil {
+		if p := pred(&cn, &hn)(false).test(s.sent); p != nil {
+			finish(p)
+			found = true
+		}
+	}
+	if len(s.acceptFromPeers(pred(&peerCN, &peerHN))) > 0 {
+		if !found || peerHN > hn || (peerHN == hn && peerCN > cn) {
+			cn, hn = peerCN, peerHN
+		}
+		found = true
+	}
+	if found {
 		s.C.N = cn
 		s.C.X = s.B.X
 		s.H.N = hn
 		s.H.X = s.B.X
-		return true
 	}
-	return false
+	return found
 }
 
 func (s *Slot) Msg() *Msg {
@@ -354,22 +492,27 @@ func (s *Slot) maybeScheduleUpd() {
 		// Don't bother if a timer's already armed.
 		return
 	}
-	nodeIDs := s.findQuorum(fpred(func(msg *Msg) bool {
-		return msg.bN() >= s.B.N
+	bn := s.B.N
+	nodeIDs := s.findQuorum(keyedPred("bN>=", strconv.Itoa(bn), func(msg *Msg) bool {
+		return msg.bN() >= bn
 	}))
 	if len(nodeIDs) == 0 {
 		return
 	}
-	s.Upd = time.AfterFunc(time.Duration((1+s.B.N)*int(DeferredUpdateInterval)), func() {
+	d := time.Duration(1+s.B.N) * s.V.cfg.DeferredUpdateInterval
+	s.Upd = s.V.clock.AfterFunc(d, func() {
 		s.V.deferredUpdate(s)
 	})
+	s.V.obs.TimerArmed(s.ID, s.B, d)
 }
 
-func (s *Slot) deferredUpdate() {
+func (s *Slot) deferredUpdate() error {
 	if s.Upd == nil {
-		return
+		return nil
 	}
 
+	before := s.snapshot()
+
 	s.Upd = nil
 	s.B.N++
 	s.setBX()
@@ -381,18 +524,23 @@ func (s *Slot) deferredUpdate() {
 		s.doCommitPhase()
 	}
 
+	s.notify(before)
+
 	msg := s.Msg()
+	if msg == nil {
+		return nil
+	}
 
-	s.Logf("deferred update: %s", msg)
+	s.log(LevelDebug, "deferred update", Field{KeyResp, msg})
 
-	s.V.send <- msg
+	return s.V.emit(s, msg)
 }
 
 func (s *Slot) cancelUpd() {
 	if s.Upd == nil {
 		return
 	}
-	stopTimer(s.Upd)
+	s.Upd.Stop()
 	s.Upd = nil
 }
 
@@ -418,8 +566,9 @@ func (s *Slot) updateB() {
 		setBN   = s.B.N
 	)
 	for { // loop until no such blocking set is found
-		nodeIDs := s.findBlockingSet(fpred(func(msg *Msg) bool {
-			return msg.bN() > setBN
+		bn := setBN
+		nodeIDs := s.findBlockingSet(keyedPred("bN>", strconv.Itoa(bn), func(msg *Msg) bool {
+			return msg.bN() > bn
 		}))
 		if len(nodeIDs) == 0 {
 			break
@@ -446,27 +595,31 @@ func (s *Slot) updateB() {
 
 	// To avoid exhausting `ballot.counter`, its value must always be
 	// less then 1,000 plus the number of seconds a node has been
-	// running SCP on the current slot.  Should any of the above rules
+	// running SCP on the current slot. (Those figures are
+	// configurable; see NodeConfig.)  Should any of the above rules
 	// require increasing the counter beyond this value, a node either
 	// increases `ballot.counter` to the maximum permissible value,
 	// or, if it is already at this maximum, waits up to one second
 	// before increasing the value.
-	maxBN := 1000 + int(time.Since(s.T)/time.Second)
+	//
+	// Rather than wait here, which would stall the node, arm a timer
+	// for when the next counter value is permitted. When it fires,
+	// the slot re-runs this logic (see raiseBN).
+	maxBN := s.V.cfg.maxBallotCounter(s.elapsed())
 	if setBN <= maxBN {
 		s.B.N = setBN
 	} else if s.B.N < maxBN {
-		s.Logf("limiting B.N to %d (from %d)", maxBN, setBN)
+		s.log(LevelInfo, "limiting ballot counter", Field{"counter", maxBN}, Field{"wanted", setBN})
 		s.B.N = maxBN
+		s.scheduleBN(maxBN + 1)
 	} else {
-		setBN = maxBN + 1
-
-		// The time when it's ok to set s.B.N to setBN (i.e., after it's been running for setBN-1000 seconds)
-		oktime := s.T.Add(time.Duration(setBN-1000) * time.Second)
-		until := time.Until(oktime)
+		s.log(LevelInfo, "ballot counter at limit", Field{"counter", s.B.N}, Field{"wanted", setBN})
+		s.scheduleBN(maxBN + 1)
 
-		s.Logf("limiting B.N to %d after a %s sleep", setBN, until)
-		time.Sleep(until)
-		s.B.N = setBN
+		// The counter isn't changing after all, so restore any
+		// deferred-update timer canceled above.
+		s.maybeScheduleUpd()
+		return
 	}
 	if doSetBX {
 		s.setBX()
@@ -474,6 +627,47 @@ func (s *Slot) updateB() {
 	}
 }
 
+// Arms a timer for the time at which ballot counter n is permitted,
+// unless one is already armed.
+func (s *Slot) scheduleBN(n int) {
+	if s.bnTimer != nil {
+		return
+	}
+	oktime := s.T.Add(s.V.cfg.ballotCounterTime(n))
+	d := oktime.Sub(s.V.clock.Now())
+	s.bnTimer = s.V.clock.AfterFunc(d, func() {
+		s.V.raiseBN(s)
+	})
+}
+
+// Called when the timer armed by scheduleBN fires. Reapplies the
+// balloting rules, which may now raise B.N further than before.
+func (s *Slot) raiseBN() error {
+	if s.bnTimer == nil {
+		return nil
+	}
+	s.bnTimer = nil
+
+	before := s.snapshot()
+	msg := s.advance()
+	s.notify(before)
+	if msg == nil {
+		return nil
+	}
+
+	s.log(LevelDebug, "raised ballot counter", Field{KeyResp, msg})
+
+	return s.V.emit(s, msg)
+}
+
+func (s *Slot) cancelBN() {
+	if s.bnTimer == nil {
+		return
+	}
+	s.bnTimer.Stop()
+	s.bnTimer = nil
+}
+
 func (s *Slot) setBX() {
 	if s.Ph >= PhCommit {
 		return
@@ -492,24 +686,21 @@ func (s *Slot) setBX() {
 
 // Round tells the current (time-based) nomination round.
 //
-// Nomination round N lasts for a duration of
-// (2+N)*NomRoundInterval. Also, the first round is round 1. Via the
-// quadratic formula this tells us that after an elapsed time of T,
-// it's round 1 + ((sqrt(8T+25)-5) / 2)
+// By default, nomination round N lasts for a duration of
+// (2+N)*NomRoundInterval (see NodeConfig). The first round is round
+// 1.
 func (s *Slot) Round() int {
-	return round(time.Since(s.T))
+	return s.V.cfg.round(s.elapsed())
 }
 
-func round(d time.Duration) int {
-	elapsed := float64(d) / float64(NomRoundInterval)
-	r := math.Sqrt(8.0*elapsed + 25.0)
-	return 1 + int((r-5.0)/2.0)
+// Tells how long this slot has existed, according to its node's
+// clock.
+func (s *Slot) elapsed() time.Duration {
+	return s.V.clock.Now().Sub(s.T)
 }
 
 func (s *Slot) roundTime(r int) time.Time {
-	r--
-	intervals := r * (r + 5) / 2
-	return s.T.Add(time.Duration(intervals * int(NomRoundInterval)))
+	return s.T.Add(s.V.cfg.roundStart(r))
 }
 
 func (s *Slot) newRound() error {
@@ -526,17 +717,38 @@ func (s *Slot) newRound() error {
 		}
 		s.maxPriPeers = s.maxPriPeers.Add(peerID)
 	}
-	// s.Logf("round %d, peers %v", curRound, s.maxPriPeers)
+	// s.log(LevelDebug, "new round", Field{"round", curRound}, Field{"peers", s.maxPriPeers})
+	if curRound > s.lastRound {
+		s.V.obs.NomRound(s.ID, curRound)
+	}
 	s.lastRound = curRound
+
+	// Re-offer the node's own candidates, in case it has just become
+	// eligible.
+	before := s.snapshot()
+	s.offerCands()
+	msg := s.advance()
+	s.notify(before)
+	if msg != nil {
+		err := s.V.emit(s, msg)
+		if err != nil {
+			return err
+		}
+	}
+	if s.nextRoundTimer == nil {
+		// Nomination is over.
+		return nil
+	}
+
 	s.V.rehandle(s)
 	s.scheduleRound()
 	return nil
 }
 
 func (s *Slot) scheduleRound() {
-	dur := time.Until(s.roundTime(s.lastRound + 1))
-	// s.Logf("scheduling round %d for %s from now", s.lastRound+1, dur)
-	s.nextRoundTimer = time.AfterFunc(dur, func() {
+	dur := s.roundTime(s.lastRound + 1).Sub(s.V.clock.Now())
+	// s.log(LevelDebug, "scheduling round", Field{"round", s.lastRound + 1}, Field{"delay", dur})
+	s.nextRoundTimer = s.V.clock.AfterFunc(dur, func() {
 		s.V.newRound(s)
 	})
 }
@@ -574,16 +786,19 @@ func (s *Slot) updateYZ() {
 	var promote ValueSet
 
 	nodeIDs := s.accept(func(isQuorum bool) predicate {
+		kind := "acceptsNominated"
+		setFn := (*Msg).acceptsNominatedSet
+		if isQuorum {
+			kind = "votesOrAcceptsNominated"
+			setFn = (*Msg).votesOrAcceptsNominatedSet
+		}
 		return &valueSetPred{
 			vals:      s.X,
 			finalVals: &promote,
 			testfn: func(msg *Msg, vals ValueSet) ValueSet {
-				setFn := msg.acceptsNominatedSet
-				if isQuorum {
-					setFn = msg.votesOrAcceptsNominatedSet
-				}
-				return vals.Intersection(setFn())
+				return vals.Intersection(setFn(msg))
 			},
+			kind: kind,
 		}
 	})
 	if len(nodeIDs) > 0 {
@@ -600,6 +815,7 @@ func (s *Slot) updateYZ() {
 		testfn: func(msg *Msg, vals ValueSet) ValueSet {
 			return vals.Intersection(msg.acceptsNominatedSet())
 		},
+		kind: "acceptsNominated",
 	})
 	if len(nodeIDs) > 0 {
 		s.Z = s.Z.Union(promote)
@@ -607,48 +823,41 @@ func (s *Slot) updateYZ() {
 }
 
 // Update s.P and s.PP, the two highest accepted-prepared ballots.
-// TODO: this gives the highest accepted-prepared ballots in the
-// blocking set or, if there isn't one, in the first quorum
-// found. There might be higher accepted-prepared ballots in other
-// quorums.
+// Every candidate ballot is checked separately (see maxBallotSet), so
+// a higher ballot accepted via one quorum isn't hidden by a lower one
+// accepted via another.
 func (s *Slot) updateP() {
 	var apIn BallotSet
 
 	if !s.B.IsZero() {
-		apIn.Add(s.B)
+		apIn = apIn.Add(s.B)
 	}
 	if !s.P.IsZero() {
-		apIn.Add(s.P)
+		apIn = apIn.Add(s.P)
 		if !s.PP.IsZero() {
-			apIn.Add(s.PP)
+			apIn = apIn.Add(s.PP)
 		}
 	}
 
 	s.P = ZeroBallot
 	s.PP = ZeroBallot
 
-	var apOut BallotSet
 	peers := s.V.Peers()
 	for _, peerID := range peers {
 		if msg, ok := s.M[peerID]; ok {
 			apIn = apIn.Union(msg.votesOrAcceptsPreparedSet())
 		}
 	}
-	nodeIDs := s.accept(func(isQuorum bool) predicate {
-		return &ballotSetPred{
-			ballots:      apIn,
-			finalBallots: &apOut,
-			testfn: func(msg *Msg, ballots BallotSet) BallotSet {
-				setFn := msg.acceptsPreparedSet
-				if isQuorum {
-					setFn = msg.votesOrAcceptsPreparedSet
-				}
-				b := setFn()
-				return ballots.Intersection(b)
-			},
-		}
+	apOut := maxBallotSet(apIn, func(b Ballot) bool {
+		nodeIDs := s.accept(func(isQuorum bool) predicate {
+			if isQuorum {
+				return keyedPred("votesOrAcceptsPrepared", ballotKey(b), func(msg *Msg) bool { return msg.votesOrAcceptsPrepared(b) })
+			}
+			return keyedPred("acceptsPrepared", ballotKey(b), func(msg *Msg) bool { return msg.acceptsPrepared(b) })
+		})
+		return len(nodeIDs) > 0
 	})
-	if len(nodeIDs) > 0 {
+	if len(apOut) > 0 {
 		if !s.B.IsZero() {
 			// Exclude ballots with N > B.N, if s.B is set.
 			// If it's not set, we're still in NOMINATE phase and can set
@@ -676,24 +885,8 @@ func (s *Slot) updateP() {
 	}
 }
 
+// Logf sends a formatted message about the slot to its node's Logger
+// (see WithLogger) at LevelInfo.
 func (s *Slot) Logf(f string, a ...interface{}) {
-	f = "slot %d: " + f
-	a = append([]interface{}{s.ID}, a...)
-	s.V.Logf(f, a...)
-}
-
-// To prevent a timer created with NewTimer from firing after a call
-// to Stop, check the return value and drain the
-// channel. https://golang.org/pkg/time/#Timer.Stop
-//
-// HOWEVER, it looks like a straight read of the timer's channel can
-// sometimes block even when Stop returns false. This works around
-// that by making the drain be non-blocking.
-func stopTimer(t *time.Timer) {
-	if !t.Stop() {
-		select {
-		case <-t.C:
-		default:
-		}
-	}
+	s.log(LevelInfo, fmt.Sprintf(f, a...))
 }
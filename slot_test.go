@@ -6,23 +6,254 @@ import (
 )
 
 func TestRound(t *testing.T) {
+	const interval = time.Second
+	cfg := NodeConfig{NomRoundInterval: interval}
 	cases := []struct {
 		d    time.Duration
 		want int
 	}{
 		{0, 1},
-		{1 * NomRoundInterval, 1},
-		{2 * NomRoundInterval, 1},
-		{3 * NomRoundInterval, 2},
-		{4 * NomRoundInterval, 2},
-		{5 * NomRoundInterval, 2},
-		{6 * NomRoundInterval, 2},
-		{7 * NomRoundInterval, 3},
+		{1 * interval, 1},
+		{2 * interval, 1},
+		{3 * interval, 2},
+		{4 * interval, 2},
+		{5 * interval, 2},
+		{6 * interval, 2},
+		{7 * interval, 3},
 	}
 	for _, tc := range cases {
-		got := round(tc.d)
+		got := cfg.round(tc.d)
 		if got != tc.want {
-			t.Errorf("got round(%s) = %d, want %d", tc.d, got, tc.want)
+			t.Errorf("got cfg.round(%s) = %d, want %d", tc.d, got, tc.want)
 		}
 	}
 }
+
+func TestRoundCustomDuration(t *testing.T) {
+	cfg := NodeConfig{
+		RoundDuration: func(r int) time.Duration {
+			return time.Duration(r) * time.Second
+		},
+	}
+	cases := []struct {
+		d    time.Duration
+		want int
+	}{
+		{0, 1},
+		{999 * time.Millisecond, 1},
+		{1 * time.Second, 2},
+		{2 * time.Second, 2},
+		{3 * time.Second, 3},
+		{5 * time.Second, 3},
+		{6 * time.Second, 4},
+	}
+	for _, tc := range cases {
+		got := cfg.round(tc.d)
+		if got != tc.want {
+			t.Errorf("got cfg.round(%s) = %d, want %d", tc.d, got, tc.want)
+		}
+		if start := cfg.roundStart(got); start > tc.d {
+			t.Errorf("round %d starts at %s, after %s", got, start, tc.d)
+		}
+	}
+}
+
+func TestRoundFormula(t *testing.T) {
+	// The closed form agrees with adding up the rounds one at a time.
+	cfg := NodeConfig{NomRoundInterval: time.Second}
+	var (
+		r     = 1
+		start time.Duration
+	)
+	for d := time.Duration(0); d < 2000*time.Second; d += 250 * time.Millisecond {
+		for end := start + time.Duration(2+r)*time.Second; end <= d; end += time.Duration(2+r) * time.Second {
+			start = end
+			r++
+		}
+		if got := cfg.round(d); got != r {
+			t.Fatalf("got cfg.round(%s) = %d, want %d", d, got, r)
+		}
+		if got := cfg.roundStart(r); got != start {
+			t.Fatalf("got cfg.roundStart(%d) = %s, want %s", r, got, start)
+		}
+	}
+}
+
+func TestRoundBadDuration(t *testing.T) {
+	cfg := NodeConfig{
+		NomRoundInterval: time.Second,
+		RoundDuration: func(r int) time.Duration {
+			if r%2 == 0 {
+				return 0
+			}
+			return -time.Second
+		},
+	}
+	// Every round lasts NomRoundInterval instead.
+	if got := cfg.round(10 * time.Second); got != 11 {
+		t.Errorf("got round %d, want 11", got)
+	}
+}
+
+func TestNodeConfigIndependent(t *testing.T) {
+	ch := make(chan *Msg)
+	q := slicesToQSet([]NodeIDSet{{"a"}})
+	fast := NewNode("x", q, ch, WithConfig(NodeConfig{NomRoundInterval: time.Millisecond}))
+	dflt := NewNode("y", q, ch)
+
+	if got := fast.Config().NomRoundInterval; got != time.Millisecond {
+		t.Errorf("got NomRoundInterval %s, want 1ms", got)
+	}
+	if got := fast.Config().DeferredUpdateInterval; got != DefaultNodeConfig.DeferredUpdateInterval {
+		t.Errorf("got DeferredUpdateInterval %s, want default %s", got, DefaultNodeConfig.DeferredUpdateInterval)
+	}
+	if got := dflt.Config().NomRoundInterval; got != DefaultNodeConfig.NomRoundInterval {
+		t.Errorf("got NomRoundInterval %s, want default %s", got, DefaultNodeConfig.NomRoundInterval)
+	}
+}
+
+func TestNodeConfigDefaults(t *testing.T) {
+	ch := make(chan *Msg)
+	q := slicesToQSet([]NodeIDSet{{"a"}})
+	n := NewNode("x", q, ch, WithConfig(NodeConfig{BallotCounterBase: -1}))
+	if got := n.Config().BallotCounterBase; got != 0 {
+		t.Errorf("got BallotCounterBase %d, want 0", got)
+	}
+
+	// Changing the defaults later doesn't affect the node.
+	saved := DefaultNodeConfig
+	defer func() { DefaultNodeConfig = saved }()
+	DefaultNodeConfig.NomRoundInterval = time.Hour
+	if got := n.Config().roundDuration(1); got != 3*saved.NomRoundInterval {
+		t.Errorf("got round duration %s, want %s", got, 3*saved.NomRoundInterval)
+	}
+
+	// Negative intervals are rejected.
+	bad := NodeConfig{DeferredUpdateInterval: -time.Second}
+	if err := bad.Validate(); err == nil {
+		t.Error("got no error validating a negative interval")
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("WithConfig accepted a negative interval")
+			}
+		}()
+		NewNode("y", q, ch, WithConfig(bad))
+	}()
+	if err := (NodeConfig{RebroadcastInterval: -1}).Validate(); err != nil {
+		t.Errorf("got error %s for disabled rebroadcasting", err)
+	}
+}
+
+func TestBallotCounterCap(t *testing.T) {
+	clock := NewFakeClock(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC))
+	cfg := NodeConfig{
+		BallotCounterBase:      5,
+		BallotCounterInterval:  time.Second,
+		DeferredUpdateInterval: time.Hour,
+	}
+	q := QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("y")}}}
+	yQ := QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("x")}}}
+	ch := make(chan *Msg, 100)
+	n := NewNode("x", q, ch, WithClock(clock), WithConfig(cfg))
+
+	// y is blocking for x and has ballot counter 20,
+	// but x may not go past 5 yet.
+	// This must not block.
+	err := handleNow(n, NewMsg("y", 1, yQ, &NomTopic{Y: ValueSet{valtype(7)}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = handleNow(n, NewMsg("y", 1, yQ, &PrepTopic{B: Ballot{20, valtype(7)}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := n.pending[1]
+	if s.B.N != 5 {
+		t.Fatalf("got ballot counter %d, want 5", s.B.N)
+	}
+
+	// The node keeps processing messages while it waits.
+	n.Handle(NewMsg("y", 2, yQ, &NomTopic{X: ValueSet{valtype(8)}}))
+	for n.Step() {
+	}
+	if n.numFuture != 1 {
+		t.Errorf("got %d future message(s), want 1", n.numFuture)
+	}
+
+	// Each elapsed second permits one more.
+	for want := 6; want <= 20; want++ {
+		clock.Advance(time.Second)
+		for n.Step() {
+		}
+		if s.B.N != want {
+			t.Fatalf("after %s got ballot counter %d, want %d", s.elapsed(), s.B.N, want)
+		}
+	}
+	if s.bnTimer != nil {
+		t.Error("ballot counter timer still armed")
+	}
+
+	// The node sent its new ballot each time.
+	var last int
+	for len(ch) > 0 {
+		msg := <-ch
+		if bn := msg.bN(); bn > last {
+			last = bn
+		}
+	}
+	if last != 20 {
+		t.Errorf("got highest ballot counter sent %d, want 20", last)
+	}
+}
+
+// The first quorum found accepting some ballot as prepared must not
+// hide a higher one accepted by another quorum.
+func TestMaxPrepared(t *testing.T) {
+	var (
+		v1 = valtype(1)
+		v2 = valtype(2)
+		q  = slicesToQSet([]NodeIDSet{{"a"}, {"b"}})
+		pQ = QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("x")}}}
+	)
+
+	setup := func(t *testing.T, b, p, pp Ballot) *Slot {
+		ch := make(chan *Msg, 10)
+		n := NewNode("x", q, ch)
+		s, err := n.slot(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Ph = PhPrep
+		s.B, s.P, s.PP = b, p, pp
+		s.sent = NewMsg("x", 1, q, &PrepTopic{B: b, P: p, PP: pp})
+		s.M["a"] = NewMsg("a", 1, pQ, &PrepTopic{B: Ballot{1, v1}, P: Ballot{1, v1}})
+		s.M["b"] = NewMsg("b", 1, pQ, &PrepTopic{B: Ballot{5, v2}, P: Ballot{5, v2}})
+		return s
+	}
+
+	t.Run("P", func(t *testing.T) {
+		// x votes for both ballots. The quorum {x, a} accepts <1,v1>,
+		// {x, b} accepts <5,v2>.
+		s := setup(t, Ballot{5, v2}, Ballot{1, v1}, ZeroBallot)
+		s.updateP()
+		if want := (Ballot{5, v2}); s.P != want {
+			t.Errorf("got P %s, want %s", s.P, want)
+		}
+		if want := (Ballot{1, v1}); s.PP != want {
+			t.Errorf("got PP %s, want %s", s.PP, want)
+		}
+	})
+
+	t.Run("H", func(t *testing.T) {
+		// The quorum {x, a} confirms <1,v1>, {x, b} confirms <5,v2>.
+		s := setup(t, Ballot{5, v2}, Ballot{5, v2}, Ballot{1, v1})
+		if !s.updateH() {
+			t.Fatal("nothing confirmed prepared")
+		}
+		if want := (Ballot{5, v2}); s.H != want {
+			t.Errorf("got H %s, want %s", s.H, want)
+		}
+	})
+}
@@ -0,0 +1,77 @@
+package scp
+
+import "sort"
+
+// NodeStatus is a snapshot of a node's state, produced by Node.Status.
+type NodeStatus struct {
+	ID NodeID
+
+	// HighestExt is the highest slot for which the node has
+	// externalized a value.
+	HighestExt SlotID
+
+	// Slots describes the node's pending slots, in increasing order
+	// of slot ID.
+	Slots []SlotStatus
+
+	// NumFuture is the number of messages buffered for slots the node
+	// can't process yet.
+	NumFuture int
+}
+
+// SlotStatus is a snapshot of a pending slot's state.
+type SlotStatus struct {
+	ID    SlotID
+	Ph    Phase
+	Round int // current nomination round
+
+	X, Y, Z ValueSet // voted, accepted, and confirmed nominated
+
+	B     Ballot
+	P, PP Ballot
+	C, H  Ballot
+
+	// M holds the latest message received from each peer.
+	M map[NodeID]*Msg
+}
+
+// Status produces a snapshot of the node's state. It is safe for
+// concurrent use.
+func (n *Node) Status() NodeStatus {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	result := NodeStatus{
+		ID:         n.ID,
+		HighestExt: n.ext.Highest(),
+		NumFuture:  n.numFuture,
+	}
+	for _, s := range n.pending {
+		result.Slots = append(result.Slots, s.status())
+	}
+	sort.Slice(result.Slots, func(i, j int) bool {
+		return result.Slots[i].ID < result.Slots[j].ID
+	})
+	return result
+}
+
+func (s *Slot) status() SlotStatus {
+	m := make(map[NodeID]*Msg, len(s.M))
+	for k, v := range s.M {
+		m[k] = v
+	}
+	return SlotStatus{
+		ID:    s.ID,
+		Ph:    s.Ph,
+		Round: s.Round(),
+		X:     s.X,
+		Y:     s.Y,
+		Z:     s.Z,
+		B:     s.B,
+		P:     s.P,
+		PP:    s.PP,
+		C:     s.C,
+		H:     s.H,
+		M:     m,
+	}
+}
@@ -0,0 +1,78 @@
+package scp
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestStatus(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	q := QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("y")}}}
+	yQ := QSet{T: 1, M: []QSetMember{{N: nodeIDPtr("x")}, {N: nodeIDPtr("z")}}}
+	ch := make(chan *Msg)
+	n := NewNode("x", q, ch)
+	go n.Run(ctx)
+
+	// Query the node concurrently with its processing.
+	// (Run with -race.)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for ctx.Err() == nil {
+			n.Status()
+			n.AllKnown()
+			n.MsgsSince(0)
+			n.HighestExt()
+		}
+	}()
+
+	// Peer messages are processed in order,
+	// so the slot-2 message is buffered before x leaves nomination.
+	n.Handle(NewMsg("y", 2, yQ, &NomTopic{X: ValueSet{valtype(8)}}))
+	n.Handle(NewMsg("y", 1, yQ, &NomTopic{X: ValueSet{valtype(7)}}))
+	n.Handle(NewMsg("y", 1, yQ, &PrepTopic{B: Ballot{1, valtype(7)}, P: Ballot{1, valtype(7)}}))
+
+	// Wait for x to move to the balloting phase.
+	for msg := range ch {
+		if _, ok := msg.T.(*PrepTopic); ok {
+			break
+		}
+	}
+
+	cancel()
+	wg.Wait()
+
+	// Run may be blocked sending on ch,
+	// but it doesn't hold the lock while doing so.
+	st := n.Status()
+	if st.ID != "x" || st.HighestExt != 0 {
+		t.Errorf("got ID %s, HighestExt %d", st.ID, st.HighestExt)
+	}
+	if st.NumFuture != 1 {
+		t.Errorf("got %d future message(s), want 1", st.NumFuture)
+	}
+	if len(st.Slots) != 1 {
+		t.Fatalf("got %d slot(s), want 1", len(st.Slots))
+	}
+	s := st.Slots[0]
+	if s.ID != 1 || s.Ph != PhPrep || s.Round != 1 {
+		t.Errorf("got slot %d, phase %s, round %d; want slot 1, phase PREPARE, round 1", s.ID, s.Ph, s.Round)
+	}
+	if want := (Ballot{1, valtype(7)}); !s.B.Equal(want) || !s.P.Equal(want) {
+		t.Errorf("got B %s, P %s; want %s", s.B, s.P, want)
+	}
+	if !s.X.Contains(valtype(7)) && !s.Y.Contains(valtype(7)) {
+		t.Errorf("got X %v, Y %v; want 7 in one of them", s.X, s.Y)
+	}
+	if msg := s.M["y"]; msg == nil || msg.I != 1 {
+		t.Errorf("got latest message from y %v", msg)
+	}
+	if got, want := n.AllKnown(), toNodeIDSet("y z"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got AllKnown %v, want %v", got, want)
+	}
+}